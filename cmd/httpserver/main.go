@@ -2,25 +2,32 @@
 package main
 
 import (
-	"crypto/sha256"
-	"errors"
+	"context"
+	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/colfarl/httpfromtcp/internal/accesslog"
+	"github.com/colfarl/httpfromtcp/internal/compress"
+	"github.com/colfarl/httpfromtcp/internal/cors"
+	"github.com/colfarl/httpfromtcp/internal/fileserver"
 	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/proxy"
+	"github.com/colfarl/httpfromtcp/internal/ratelimit"
 	"github.com/colfarl/httpfromtcp/internal/request"
 	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/colfarl/httpfromtcp/internal/router"
 	"github.com/colfarl/httpfromtcp/internal/server"
 )
 
-const port = 42069
 const badRequestHTML = `<html>
   <head>
     <title>400 Bad Request</title>
@@ -51,85 +58,32 @@ const okHTML = `<html>
   </body>
 </html>`
 
-func basicHandler(res *response.Writer, req *request.Request) {
+func badRequestHandler(res *response.Writer, req *request.Request) {
 	header := headers.NewHeaders()
-	if req.RequestLine.RequestTarget == "/yourproblem" {
-		res.WriteStatusLine(400)
-		header.Set("Content-Type", "text/html")
-		header.Set("Content-Length", strconv.Itoa(len(badRequestHTML)))
-		header.Set("Connection", "close")
-		res.WriteHeaders(header)
-		res.WriteBody([]byte(badRequestHTML))
-		return
-	}
-
-	if req.RequestLine.RequestTarget == "/myproblem" {
-		res.WriteStatusLine(500)
-		header.Set("Content-Type", "text/html")
-		header.Set("Content-Length", strconv.Itoa(len(internalErrHTML)))	
-		header.Set("Connection", "close")
-		res.WriteHeaders(header)
-		res.WriteBody([]byte(internalErrHTML))
-		return
-	}
-	
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-		baseURL := "https://httpbin.org/" + strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-		resp, err := http.Get(baseURL)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer resp.Body.Close()	
-
-		res.WriteStatusLine(200)
-		header.Set("Content-Type", resp.Header.Get("Content-Type"))
-		header.Set("Host", "httpbin.org")
-		header.Set("Transfer-Encoding", "chunked")
-		res.WriteHeaders(header)
-		buf := make([]byte, 1024)
-		total := make([]byte, 0)
-		for {	
-			n, err := resp.Body.Read(buf)
-			if n > 0 {
-				res.WriteChunkedBody(buf[:n])
-				total = append(total, buf[:n]...)
-			}
-
-			if errors.Is(err, io.EOF) {
-				res.WriteChunkedBodyDone()
-				break
-			}
-			
-			if err != nil {
-				log.Fatal(err)
-			}
-		}
+	res.WriteStatusLine(400)
+	header.Set("Content-Type", "text/html")
+	header.Set("Content-Length", strconv.Itoa(len(badRequestHTML)))
+	header.Set("Connection", "close")
+	res.WriteHeaders(header)
+	res.WriteBody([]byte(badRequestHTML))
+}
 
-		hash := sha256.Sum256(total)
-		hashHex := fmt.Sprintf("%x", hash)
-		trailers := headers.NewHeaders()
-		trailers.Set("X-Content-SHA256", string(hashHex))
-		trailers.Set("X-Content-Length", strconv.Itoa(len(total)))
-		res.WriteTrailers(trailers)
-		return
-	}
+func internalErrHandler(res *response.Writer, req *request.Request) {
+	header := headers.NewHeaders()
+	res.WriteStatusLine(500)
+	header.Set("Content-Type", "text/html")
+	header.Set("Content-Length", strconv.Itoa(len(internalErrHTML)))	
+	header.Set("Connection", "close")
+	res.WriteHeaders(header)
+	res.WriteBody([]byte(internalErrHTML))
+}
 
-		
-	if req.RequestLine.RequestTarget == "/video" {
-		res.WriteStatusLine(200)
-		header.Set("Content-Type", "video/mp4")
-		video, err := os.ReadFile("assets/vim.mp4")
-		if err != nil {
-			log.Fatal(err)
-			return
-		}
+func videoHandler(res *response.Writer, req *request.Request) {
+	fileserver.ServeFile(res, req, "assets/vim.mp4")
+}
 
-		header.Set("Content-Length", strconv.Itoa(len(video)))
-		header.Set("Connection", "close")
-		res.WriteHeaders(header)
-		res.WriteBody([]byte(video))
-		return
-	}
+func okHandler(res *response.Writer, req *request.Request) {
+	header := headers.NewHeaders()
 	res.WriteStatusLine(200)
 	header.Set("Content-Type", "text/html")
 	header.Set("Content-Length", strconv.Itoa(len(okHTML)))
@@ -137,17 +91,207 @@ func basicHandler(res *response.Writer, req *request.Request) {
 	res.WriteBody([]byte(okHTML))
 }
 
+func newRouter() (*router.Router, error) {
+	httpbin, err := proxy.New(proxy.Config{
+		Target:      "https://httpbin.org",
+		StripPrefix: "/httpbin",
+		RewriteHost: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rt := router.New()
+	routes := []struct {
+		pattern string
+		handler server.Handler
+	}{
+		{"/yourproblem", badRequestHandler},
+		{"/myproblem", internalErrHandler},
+		{"/httpbin/{path...}", httpbin.Handler()},
+		{"/video", videoHandler},
+		{"/assets/{path...}", fileserver.New(fileserver.Config{Root: "assets", StripPrefix: "/assets", Listing: true})},
+		{"/{path...}", okHandler},
+	}
+	for _, r := range routes {
+		if err := rt.Handle(r.pattern, r.handler); err != nil {
+			return nil, err
+		}
+	}
+	if *backends != "" {
+		strategy, ok := strategies[*lbStrategy]
+		if !ok {
+			return nil, fmt.Errorf("unknown load balancing strategy: %s", *lbStrategy)
+		}
+		pool, err := proxy.NewPool(proxy.PoolConfig{
+			Targets:         strings.Split(*backends, ","),
+			Proxy:           proxy.Config{StripPrefix: "/proxy"},
+			Strategy:        strategy,
+			HealthCheckPath: *healthPath,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := rt.Handle("/proxy/{path...}", pool.Handler()); err != nil {
+			return nil, err
+		}
+	}
+	return rt, nil
+}
+
+var addr = flag.String("addr", "127.0.0.1:42069", "address to listen on, e.g. :42069, [::1]:42069 or unix:///run/httpserver.sock")
+var drainTimeout = flag.Duration("drain-timeout", 30*time.Second, "how long to wait for in-flight requests on shutdown or restart")
+var maxConns = flag.Int("max-conns", 0, "maximum concurrent connections, 0 for unlimited")
+var queueSize = flag.Int("queue-size", 0, "connections that may wait for a slot once -max-conns is reached, 0 to turn them away at once")
+var queueTimeout = flag.Duration("queue-timeout", 5*time.Second, "how long a connection may wait in the -queue-size queue, 0 for no limit")
+var maxRequests = flag.Int("max-requests", 0, "maximum concurrent in-flight requests, 0 for unlimited")
+var maxBodySize = flag.Int64("max-body-size", 0, "largest request body accepted in bytes, refused with 413 before it is read; 0 for unlimited")
+var rateLimit = flag.Float64("rate-limit", 0, "requests per second allowed per client IP, 0 to disable")
+var rateBurst = flag.Int("rate-burst", 20, "requests a client IP may make at once under -rate-limit")
+var backends = flag.String("backends", "", "comma separated upstream URLs to load balance under /proxy/")
+var lbStrategy = flag.String("lb-strategy", "round-robin", "how -backends are chosen: round-robin, least-conns or hash")
+var healthPath = flag.String("health-path", "", "path polled on each of -backends to check its health, empty to disable")
+var compression = flag.Bool("compress", true, "gzip or deflate responses for clients that accept it")
+var decodeRequests = flag.Bool("decode-requests", false, "transparently decode gzip/deflate request bodies, up to 10 MiB decoded")
+var corsOrigins = flag.String("cors-origins", "", "comma separated origins allowed to call the server cross-origin, e.g. https://*.example.com or *")
+var logFormat = flag.String("log-format", "combined", "access log format: common, combined or json")
+
+var tlsCert = flag.String("tls-cert", "", "PEM certificate file; enables TLS together with -tls-key")
+var tlsKey = flag.String("tls-key", "", "PEM private key file for -tls-cert")
+var tlsClientCA = flag.String("tls-client-ca", "", "PEM CA file used to require and verify client certificates")
+var tlsSelfSigned = flag.Bool("tls-self-signed", false, "serve TLS with a generated certificate for localhost")
+
+var strategies = map[string]proxy.Strategy{
+	"round-robin": proxy.RoundRobin,
+	"least-conns": proxy.LeastConnections,
+	"hash":        proxy.ConsistentHash,
+}
+
+var logFormats = map[string]accesslog.Format{
+	"common":   accesslog.FormatCommon,
+	"combined": accesslog.FormatCombined,
+	"json":     accesslog.FormatJSON,
+}
+
+// tlsOption builds the TLS server option from the flags, or returns nil
+// when TLS is not requested
+func tlsOption() (server.Option, error) {
+	cert := server.Certificate{CertFile: *tlsCert, KeyFile: *tlsKey}
+	if *tlsSelfSigned {
+		dir, err := os.MkdirTemp("", "httpserver-tls")
+		if err != nil {
+			return nil, err
+		}
+		cert = server.Certificate{
+			CertFile: filepath.Join(dir, "localhost.crt"),
+			KeyFile:  filepath.Join(dir, "localhost.key"),
+		}
+		if err := server.WriteSelfSignedCert(cert.CertFile, cert.KeyFile, "localhost", "127.0.0.1", "::1"); err != nil {
+			return nil, err
+		}
+		log.Println("Generated self-signed certificate", cert.CertFile)
+	}
+	if cert.CertFile == "" && cert.KeyFile == "" {
+		return nil, nil
+	}
+	if cert.CertFile == "" || cert.KeyFile == "" {
+		return nil, fmt.Errorf("both -tls-cert and -tls-key are required")
+	}
+	return server.WithTLS(server.TLSConfig{
+		Certificates:   []server.Certificate{cert},
+		ClientCAFile:   *tlsClientCA,
+		ReloadInterval: 10 * time.Second,
+	}), nil
+}
 
 func main() {
-	server, err := server.Serve(port, basicHandler)
+	flag.Parse()
+	rt, err := newRouter()
+	if err != nil {
+		log.Fatalf("Error building routes: %v", err)
+	}
+
+	format, ok := logFormats[*logFormat]
+	if !ok {
+		log.Fatalf("Unknown log format: %s", *logFormat)
+	}
+	accessLog := accesslog.New(os.Stdout, format)
+	middleware := []server.Middleware{accesslog.Middleware(accessLog)}
+	if *corsOrigins != "" {
+		middleware = append(middleware, cors.Middleware(cors.Config{
+			AllowedOrigins: strings.Split(*corsOrigins, ","),
+			AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"*"},
+			MaxAge:         10 * time.Minute,
+		}))
+	}
+	if *rateLimit > 0 {
+		limiter := ratelimit.New(ratelimit.Config{Rate: *rateLimit, Burst: *rateBurst})
+		middleware = append(middleware, limiter.Middleware())
+	}
+	if *decodeRequests {
+		middleware = append(middleware, compress.DecodeRequests(0))
+	}
+	if *compression {
+		middleware = append(middleware, compress.Middleware(compress.Config{}))
+	}
+	handler := server.Chain(rt.Handler(), middleware...)
+
+	opts := []server.Option{
+		server.WithParseErrorHook(accesslog.ParseErrorHook(accessLog)),
+		server.WithStaleSocketRemoval(),
+		server.WithMaxBodySize(*maxBodySize),
+		server.WithLimits(server.Limits{
+			MaxConns:     *maxConns,
+			MaxRequests:  *maxRequests,
+			QueueSize:    *queueSize,
+			QueueTimeout: *queueTimeout,
+		}),
+	}
+	tlsOpt, err := tlsOption()
+	if err != nil {
+		log.Fatalf("Error configuring TLS: %v", err)
+	}
+	if tlsOpt != nil {
+		opts = append(opts, tlsOpt)
+	}
+	inherited, err := server.InheritedListeners()
+	if err != nil {
+		log.Fatalf("Error reading inherited sockets: %v", err)
+	}
+	var srv *server.Server
+	if len(inherited) > 0 {
+		srv, err = server.ServeListener(inherited[0], handler, opts...)
+	} else {
+		srv, err = server.ServeAddr(*addr, handler, opts...)
+	}
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
-	log.Println("Server started on port", port)
+	defer srv.Close()
+	log.Println("Server started on", srv.Listener.Addr())
+	if err := server.NotifyReady(); err != nil {
+		log.Printf("Error notifying parent: %v", err)
+	}
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, append(restartSignals, syscall.SIGINT, syscall.SIGTERM)...)
+	for sig := range sigChan {
+		if slices.Contains(restartSignals, sig) {
+			child, err := srv.Handoff(*drainTimeout)
+			if err != nil {
+				log.Printf("Error restarting: %v", err)
+				continue
+			}
+			log.Println("Handed off to pid", child.Pid)
+		}
+		break
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error draining connections: %v", err)
+	}
 	log.Println("Server gracefully stopped")
 }
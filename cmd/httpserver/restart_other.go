@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// restartSignals is empty where SIGHUP and SIGUSR2 do not exist
+var restartSignals = []os.Signal{}
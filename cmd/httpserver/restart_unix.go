@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// restartSignals trigger a handoff to a freshly started copy of the server
+var restartSignals = []os.Signal{syscall.SIGHUP, syscall.SIGUSR2}
@@ -0,0 +1,173 @@
+// Package accesslog records one structured log entry per request
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/colfarl/httpfromtcp/internal/server"
+)
+
+type Format int
+
+const (
+	// FormatCommon renders entries in the NCSA Common Log Format
+	FormatCommon Format = iota
+	// FormatCombined is FormatCommon plus the referer and user agent
+	FormatCombined
+	// FormatJSON renders every attribute as a JSON object per line
+	FormatJSON
+)
+
+// attribute keys shared by every entry so that all formats, and parse
+// failures, carry the same structure
+const (
+	KeyMethod     = "method"
+	KeyTarget     = "target"
+	KeyProto      = "proto"
+	KeyStatus     = "status"
+	KeyBytes      = "bytes"
+	KeyDuration   = "duration"
+	KeyRemoteAddr = "remote_addr"
+	KeyUserAgent  = "user_agent"
+	KeyReferer    = "referer"
+	KeyError      = "error"
+)
+
+const message = "access"
+
+// New returns a logger that writes access entries to w in format
+func New(w io.Writer, format Format) *slog.Logger {
+	switch format {
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, nil))
+	default:
+		return slog.New(&clfHandler{out: w, mu: &sync.Mutex{}, combined: format == FormatCombined})
+	}
+}
+
+// Middleware logs every request that passes through it to logger
+func Middleware(logger *slog.Logger) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+
+			userAgent, _ := req.Headers.Get("User-Agent")
+			referer, _ := req.Headers.Get("Referer")
+			logger.LogAttrs(context.Background(), slog.LevelInfo, message,
+				slog.String(KeyMethod, req.RequestLine.Method),
+				slog.String(KeyTarget, req.RequestLine.RequestTarget),
+				slog.String(KeyProto, "HTTP/"+req.RequestLine.HttpVersion),
+				slog.Int(KeyStatus, int(w.Status())),
+				slog.Int(KeyBytes, w.BytesWritten()),
+				slog.Duration(KeyDuration, time.Since(start)),
+				slog.String(KeyRemoteAddr, req.RemoteAddr),
+				slog.String(KeyUserAgent, userAgent),
+				slog.String(KeyReferer, referer),
+			)
+		}
+	}
+}
+
+// ParseErrorHook returns a server hook that logs requests which could not
+// be parsed using the same keys as Middleware
+func ParseErrorHook(logger *slog.Logger) func(remoteAddr string, err error) {
+	return func(remoteAddr string, err error) {
+		logger.LogAttrs(context.Background(), slog.LevelWarn, message,
+			slog.String(KeyMethod, ""),
+			slog.String(KeyTarget, ""),
+			slog.String(KeyProto, ""),
+			slog.Int(KeyStatus, 0),
+			slog.Int(KeyBytes, 0),
+			slog.Duration(KeyDuration, 0),
+			slog.String(KeyRemoteAddr, remoteAddr),
+			slog.String(KeyUserAgent, ""),
+			slog.String(KeyReferer, ""),
+			slog.String(KeyError, err.Error()),
+		)
+	}
+}
+
+// clfHandler is a slog.Handler that renders records as Common or Combined
+// Log Format lines
+type clfHandler struct {
+	out      io.Writer
+	mu       *sync.Mutex
+	combined bool
+	attrs    []slog.Attr
+}
+
+func (h *clfHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *clfHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *clfHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func (h *clfHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := make(map[string]slog.Value)
+	for _, a := range h.attrs {
+		fields[a.Key] = a.Value
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		fields[a.Key] = a.Value
+		return true
+	})
+
+	str := func(key string) string {
+		v, ok := fields[key]
+		if !ok || v.String() == "" {
+			return "-"
+		}
+		return v.String()
+	}
+	num := func(key string) string {
+		v, ok := fields[key]
+		if !ok || v.Kind() != slog.KindInt64 || v.Int64() == 0 {
+			return "-"
+		}
+		return strconv.FormatInt(v.Int64(), 10)
+	}
+
+	host := str(KeyRemoteAddr)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	requestLine := "-"
+	if str(KeyMethod) != "-" {
+		requestLine = fmt.Sprintf("%s %s %s", str(KeyMethod), str(KeyTarget), str(KeyProto))
+	}
+
+	line := fmt.Sprintf("%s - - [%s] %q %s %s",
+		host,
+		r.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		requestLine,
+		num(KeyStatus),
+		num(KeyBytes),
+	)
+	if h.combined {
+		line += fmt.Sprintf(" %q %q", str(KeyReferer), str(KeyUserAgent))
+	}
+	line += "\n"
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.out, line)
+	return err
+}
@@ -0,0 +1,72 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func run(t *testing.T, format Format) string {
+	t.Helper()
+	out := &bytes.Buffer{}
+	h := Middleware(New(out, format))(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers.NewHeaders())
+		w.WriteBody([]byte("hello"))
+	})
+
+	req, err := request.RequestFromReader(strings.NewReader("GET /coffee HTTP/1.1\r\nUser-Agent: curl/8.0 (x86_64)\r\nReferer: http://example.com/\r\n\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = "10.0.0.7:51234"
+	w := response.NewWriter(&bytes.Buffer{})
+	h(&w, req)
+	return out.String()
+}
+
+func TestMiddlewareFormats(t *testing.T) {
+	// Test: Common Log Format
+	line := run(t, FormatCommon)
+	assert.Regexp(t, regexp.MustCompile(`^10\.0\.0\.7 - - \[[^\]]+\] "GET /coffee HTTP/1\.1" 200 5\n$`), line)
+
+	// Test: Combined Log Format
+	line = run(t, FormatCombined)
+	assert.Regexp(t, regexp.MustCompile(`^10\.0\.0\.7 - - \[[^\]]+\] "GET /coffee HTTP/1\.1" 200 5 "http://example.com/" "curl/8.0 \(x86_64\)"\n$`), line)
+
+	// Test: JSON
+	line = run(t, FormatJSON)
+	entry := map[string]any{}
+	require.NoError(t, json.Unmarshal([]byte(line), &entry))
+	assert.Equal(t, "GET", entry[KeyMethod])
+	assert.Equal(t, "/coffee", entry[KeyTarget])
+	assert.Equal(t, "HTTP/1.1", entry[KeyProto])
+	assert.Equal(t, float64(200), entry[KeyStatus])
+	assert.Equal(t, float64(5), entry[KeyBytes])
+	assert.Equal(t, "10.0.0.7:51234", entry[KeyRemoteAddr])
+	assert.Equal(t, "curl/8.0 (x86_64)", entry[KeyUserAgent])
+	assert.Contains(t, entry, KeyDuration)
+}
+
+func TestParseErrorHook(t *testing.T) {
+	// Test: Common Log Format
+	out := &bytes.Buffer{}
+	ParseErrorHook(New(out, FormatCommon))("10.0.0.7:51234", errors.New("bad request line"))
+	assert.Regexp(t, regexp.MustCompile(`^10\.0\.0\.7 - - \[[^\]]+\] "-" - -\n$`), out.String())
+
+	// Test: JSON
+	out.Reset()
+	ParseErrorHook(New(out, FormatJSON))("10.0.0.7:51234", errors.New("bad request line"))
+	entry := map[string]any{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+	assert.Equal(t, "bad request line", entry[KeyError])
+	assert.Equal(t, "10.0.0.7:51234", entry[KeyRemoteAddr])
+	assert.Equal(t, "", entry[KeyMethod])
+}
@@ -0,0 +1,336 @@
+// Package client sends requests to HTTP/1.1 servers over pooled
+// connections
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+)
+
+// Config tunes connection handling
+type Config struct {
+	// DialTimeout bounds establishing a connection, 10 seconds by default
+	DialTimeout time.Duration
+	// TLSConfig is used for https URLs, the system roots by default
+	TLSConfig *tls.Config
+
+	// MaxIdlePerHost is how many idle connections are kept for reuse per
+	// host, 2 by default
+	MaxIdlePerHost int
+	// IdleTimeout is how long an idle connection is kept, 90 seconds by
+	// default
+	IdleTimeout time.Duration
+
+	// MaxBodySize bounds response bodies, 64 MiB by default
+	MaxBodySize int64
+}
+
+const (
+	defaultDialTimeout    = 10 * time.Second
+	defaultMaxIdlePerHost = 2
+	defaultIdleTimeout    = 90 * time.Second
+	defaultMaxBodySize    = 64 << 20
+	// maxHeaderBytes is allowed on top of MaxBodySize for the status
+	// line, headers and trailers
+	maxHeaderBytes = 1 << 20
+)
+
+// ErrBodyTooLarge means the response body exceeded Config.MaxBodySize
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// Client sends requests, keeping connections open between them
+type Client struct {
+	cfg Config
+
+	mu   sync.Mutex
+	idle map[string][]*conn
+}
+
+type conn struct {
+	net.Conn
+	br       *bufio.Reader
+	idleFrom time.Time
+}
+
+func New(cfg Config) *Client {
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = defaultDialTimeout
+	}
+	if cfg.MaxIdlePerHost <= 0 {
+		cfg.MaxIdlePerHost = defaultMaxIdlePerHost
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = defaultIdleTimeout
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = defaultMaxBodySize
+	}
+	return &Client{cfg: cfg, idle: make(map[string][]*conn)}
+}
+
+// NewRequest builds a request for an absolute http or https URL
+func NewRequest(method, rawURL string, body []byte) (*request.Request, error) {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	req := &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        method,
+			RequestTarget: u.String(),
+			HttpVersion:   "1.1",
+		},
+		Headers: headers.NewHeaders(),
+		Body:    body,
+	}
+	req.Headers.Set("Host", u.Host)
+	return req, nil
+}
+
+func parseURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme: %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in URL: %q", rawURL)
+	}
+	return u, nil
+}
+
+// Get fetches rawURL
+func (c *Client) Get(ctx context.Context, rawURL string) (*response.Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(ctx, req)
+}
+
+// Do sends req and reads the whole response. The request target may be
+// an absolute URL, which is sent in origin form; otherwise the server is
+// taken from the Host header and reached over plain HTTP. A connection
+// that turns out to have been closed by the server while idle is replaced
+// transparently for idempotent requests.
+func (c *Client) Do(ctx context.Context, req *request.Request) (*response.Response, error) {
+	u, err := targetURL(req)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		cn, reused, err := c.get(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		resp, reusable, err := c.roundTrip(ctx, cn, req, u)
+		if err != nil {
+			cn.Close()
+			if reused && errors.Is(err, errStale) && idempotent(req.RequestLine.Method) {
+				continue
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, err
+		}
+		if reusable && resp.KeepAlive() {
+			c.put(u, cn)
+		} else {
+			cn.Close()
+		}
+		return resp, nil
+	}
+}
+
+// errStale marks a reused connection that the server closed before
+// answering, which is safe to retry on a new one
+var errStale = errors.New("connection closed before response")
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func targetURL(req *request.Request) (*url.URL, error) {
+	target := req.RequestLine.RequestTarget
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return parseURL(target)
+	}
+	host, ok := req.Headers.Get("Host")
+	if !ok || host == "" {
+		return nil, fmt.Errorf("request has neither an absolute target nor a Host header")
+	}
+	return parseURL("http://" + host + target)
+}
+
+func hostKey(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return u.Scheme + "://" + net.JoinHostPort(u.Hostname(), port)
+}
+
+// get returns an idle connection to u's host, or dials a new one
+func (c *Client) get(ctx context.Context, u *url.URL) (*conn, bool, error) {
+	key := hostKey(u)
+	c.mu.Lock()
+	for len(c.idle[key]) > 0 {
+		conns := c.idle[key]
+		cn := conns[len(conns)-1]
+		c.idle[key] = conns[:len(conns)-1]
+		if time.Since(cn.idleFrom) < c.cfg.IdleTimeout {
+			c.mu.Unlock()
+			return cn, true, nil
+		}
+		cn.Close()
+	}
+	c.mu.Unlock()
+
+	addr := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	dialer := &net.Dialer{Timeout: c.cfg.DialTimeout}
+	var nc net.Conn
+	var err error
+	if u.Scheme == "https" {
+		cfg := c.cfg.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = u.Hostname()
+		}
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: cfg}).DialContext(ctx, "tcp", addr)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &conn{Conn: nc, br: bufio.NewReader(nc)}, false, nil
+}
+
+// put keeps cn for reuse unless the host already has enough idle
+func (c *Client) put(u *url.URL, cn *conn) {
+	key := hostKey(u)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle[key]) >= c.cfg.MaxIdlePerHost {
+		cn.Close()
+		return
+	}
+	cn.idleFrom = time.Now()
+	c.idle[key] = append(c.idle[key], cn)
+}
+
+// CloseIdle closes every idle connection
+func (c *Client) CloseIdle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, conns := range c.idle {
+		for _, cn := range conns {
+			cn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+// roundTrip sends req on cn and reads the response. It also reports
+// whether cn is still usable, which it is not if ctx ended meanwhile and
+// left an expired deadline on it.
+func (c *Client) roundTrip(ctx context.Context, cn *conn, req *request.Request, u *url.URL) (*response.Response, bool, error) {
+	// an expired deadline unblocks whatever the round trip is waiting on
+	// once ctx ends
+	stop := context.AfterFunc(ctx, func() { cn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	if err := writeRequest(cn, req, u); err != nil {
+		return nil, false, errors.Join(errStale, err)
+	}
+	if _, err := cn.br.Peek(1); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || isReset(err) {
+			return nil, false, errStale
+		}
+		return nil, false, err
+	}
+	limited := &limitReader{r: cn.br, remaining: maxHeaderBytes + c.cfg.MaxBodySize}
+	resp, err := response.ResponseFromReader(limited, req.RequestLine.Method)
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(resp.Body)) > c.cfg.MaxBodySize {
+		return nil, false, ErrBodyTooLarge
+	}
+	// stop fails once the deadline has been set, or is about to be
+	return resp, stop(), nil
+}
+
+// limitReader fails with ErrBodyTooLarge once more than remaining bytes
+// have been read, so an oversized body is cut off while it is still
+// arriving rather than after it has been buffered
+type limitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// isReset reports whether err says the server dropped the connection, by
+// resetting it or, for writes, having closed it already
+func isReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// writeRequest serializes req in origin form
+func writeRequest(w io.Writer, req *request.Request, u *url.URL) error {
+	target := u.EscapedPath()
+	if target == "" {
+		target = "/"
+	}
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+
+	out := *req
+	out.RequestLine.RequestTarget = target
+	if _, ok := req.Headers.Get("Host"); !ok {
+		out.Headers = maps.Clone(req.Headers)
+		out.Headers.Set("Host", u.Host)
+	}
+	_, err := out.WriteTo(w)
+	return err
+}
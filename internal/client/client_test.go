@@ -0,0 +1,227 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testServer answers requests on each connection with reply until reply
+// returns "" or asks to close, counting the connections it accepted
+func testServer(t *testing.T, reply func(req *request.Request) string) (string, *atomic.Int32) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	conns := &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conn.Close()
+				for {
+					req, err := request.RequestFromReader(conn)
+					if err != nil {
+						return
+					}
+					out := reply(req)
+					if out == "" {
+						return
+					}
+					conn.Write([]byte(out))
+					if strings.Contains(out, "Connection: close") {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return "http://" + l.Addr().String(), conns
+}
+
+func TestClientBodies(t *testing.T) {
+	base, _ := testServer(t, func(req *request.Request) string {
+		switch req.RequestLine.RequestTarget {
+		case "/length":
+			return "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"
+		case "/chunked":
+			return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n2;x=y\r\nde\r\n0\r\nX-Sum: 42\r\n\r\n"
+		case "/close":
+			return "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nuntil close"
+		case "/interim":
+			return "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n"
+		case "/echo?q=1":
+			host, _ := req.Headers.Get("Host")
+			return "HTTP/1.1 201 Created\r\nContent-Length: " + strconv.Itoa(len(req.Body)+len(host)+1) + "\r\n\r\n" + host + " " + string(req.Body)
+		}
+		return "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"
+	})
+	c := New(Config{})
+	ctx := context.Background()
+
+	// Test: Content-Length body
+	resp, err := c.Get(ctx, base+"/length")
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, resp.StatusLine.StatusCode)
+	assert.Equal(t, "OK", resp.StatusLine.ReasonPhrase)
+	assert.Equal(t, "hello", string(resp.Body))
+
+	// Test: Chunked body with trailers
+	resp, err = c.Get(ctx, base+"/chunked")
+	require.NoError(t, err)
+	assert.Equal(t, "abcde", string(resp.Body))
+	sum, _ := resp.Trailers.Get("X-Sum")
+	assert.Equal(t, "42", sum)
+
+	// Test: Body delimited by closing the connection
+	resp, err = c.Get(ctx, base+"/close")
+	require.NoError(t, err)
+	assert.Equal(t, "until close", string(resp.Body))
+
+	// Test: Interim responses are skipped
+	resp, err = c.Get(ctx, base+"/interim")
+	require.NoError(t, err)
+	assert.Equal(t, response.NoContent, resp.StatusLine.StatusCode)
+
+	// Test: Body and query are sent
+	req, err := NewRequest("POST", base+"/echo?q=1", []byte("payload"))
+	require.NoError(t, err)
+	resp, err = c.Do(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, response.Created, resp.StatusLine.StatusCode)
+	assert.Equal(t, strings.TrimPrefix(base, "http://")+" payload", string(resp.Body))
+
+	// Test: Bad URLs
+	_, err = c.Get(ctx, "ftp://example.com/")
+	assert.Error(t, err)
+}
+
+func TestClientReuse(t *testing.T) {
+	base, conns := testServer(t, func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	})
+	c := New(Config{})
+	defer c.CloseIdle()
+
+	// Test: Keep-alive connections are reused
+	for i := 0; i < 5; i++ {
+		resp, err := c.Get(context.Background(), base+"/")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(resp.Body))
+	}
+	assert.Equal(t, int32(1), conns.Load())
+
+	// Test: Connections marked close are not
+	base, conns = testServer(t, func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok"
+	})
+	for i := 0; i < 3; i++ {
+		_, err := c.Get(context.Background(), base+"/")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(3), conns.Load())
+}
+
+func TestClientStaleConnection(t *testing.T) {
+	// the server hangs up on every other request despite keep-alive
+	served := atomic.Int32{}
+	base, conns := testServer(t, func(req *request.Request) string {
+		if served.Add(1)%2 == 0 {
+			return ""
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	})
+	c := New(Config{})
+
+	// Test: A connection the server dropped while idle is replaced
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(context.Background(), base+"/")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(resp.Body))
+	}
+	assert.Equal(t, int32(3), conns.Load())
+}
+
+func TestClientTimeout(t *testing.T) {
+	base, _ := testServer(t, func(req *request.Request) string {
+		time.Sleep(200 * time.Millisecond)
+		return "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+	})
+	c := New(Config{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := c.Get(ctx, base+"/")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Test: Oversized bodies are refused
+	base, _ = testServer(t, func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n" + strings.Repeat("x", 100)
+	})
+	c = New(Config{MaxBodySize: 10})
+	_, err = c.Get(context.Background(), base+"/")
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+// cancelOnRead cancels the round trip as soon as the response has been
+// read, and waits for the cancellation to reach the connection
+type cancelOnRead struct {
+	net.Conn
+	cancel      context.CancelFunc
+	deadlineSet chan struct{}
+}
+
+func (c *cancelOnRead) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.cancel()
+		<-c.deadlineSet
+	}
+	return n, err
+}
+
+func (c *cancelOnRead) SetDeadline(t time.Time) error {
+	close(c.deadlineSet)
+	return c.Conn.SetDeadline(t)
+}
+
+func TestClientCancelledAfterResponse(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		if _, err := request.RequestFromReader(server); err == nil {
+			server.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := &cancelOnRead{Conn: client, cancel: cancel, deadlineSet: make(chan struct{})}
+	cn := &conn{Conn: nc, br: bufio.NewReader(nc)}
+	req, err := request.RequestFromReader(strings.NewReader("GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	u, err := targetURL(req)
+	require.NoError(t, err)
+
+	// Test: The response is returned, but the connection is not reused
+	// with the expired deadline ctx left on it
+	resp, reusable, err := New(Config{}).roundTrip(ctx, cn, req, u)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(resp.Body))
+	assert.False(t, reusable)
+}
@@ -0,0 +1,237 @@
+// Package compress encodes response bodies with gzip or deflate for
+// clients that accept them, and decodes request bodies clients encoded
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/colfarl/httpfromtcp/internal/server"
+)
+
+// Config tunes what gets compressed
+type Config struct {
+	// Level is the gzip/zlib compression level, gzip.DefaultCompression by
+	// default
+	Level int
+	// MinSize is the smallest Content-Length worth compressing, 1024 bytes
+	// by default. Chunked responses of unknown length are always compressed.
+	MinSize int
+}
+
+const defaultMinSize = 1024
+
+// defaultMaxDecodedSize bounds decoded request bodies when DecodeRequests
+// is given no limit
+const defaultMaxDecodedSize = 10 << 20
+
+// compressedTypes are media types whose content is already compressed, so
+// encoding them again only costs time
+var compressedTypes = []string{
+	"image/", "video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip",
+	"application/x-bzip2", "application/x-xz", "application/x-7z-compressed",
+	"application/x-rar-compressed", "application/zstd", "application/wasm",
+	"application/ogg", "application/pdf",
+}
+
+// Negotiate picks gzip or deflate from an Accept-Encoding value by their
+// q-values, preferring gzip on a tie. It returns "" when the client
+// accepts neither.
+func Negotiate(acceptEncoding string) string {
+	q := map[string]float64{}
+	for _, element := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(element, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		weight := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+			w, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || w < 0 || w > 1 {
+				continue
+			}
+			weight = w
+		}
+		// x-gzip is an old alias that RFC 9110 still asks us to honour
+		if coding == "x-gzip" {
+			coding = "gzip"
+		}
+		q[coding] = weight
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		weight, ok := q[coding]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ = coding, weight
+		}
+	}
+	return best
+}
+
+// Middleware compresses responses when the client accepts it. Responses
+// that are too small, already encoded, of a compressed type, partial or
+// without a body are sent as they are. A compressed response loses its
+// Content-Length and is sent chunked instead. HEAD gets the same header
+// changes as GET; dropping any body is left to the router.
+func Middleware(cfg Config) server.Middleware {
+	if cfg.Level == 0 {
+		cfg.Level = gzip.DefaultCompression
+	}
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = defaultMinSize
+	}
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			head := req.RequestLine.Method == "HEAD"
+			acceptEncoding, _ := req.Headers.Get("Accept-Encoding")
+			encoding := Negotiate(acceptEncoding)
+
+			cw := &compressWriter{head: head}
+			w.WrapBody(func(dst io.Writer) io.Writer {
+				cw.dst = dst
+				return cw
+			})
+			w.OnHeaders(func(status response.StatusCode, h headers.Headers) {
+				if !cfg.compressible(status, h) {
+					return
+				}
+				h.AddVary("Accept-Encoding")
+				if encoding == "" || cw.start(encoding, cfg.Level) != nil {
+					return
+				}
+				h.Set("Content-Encoding", encoding)
+				if _, ok := h.Get("Content-Length"); ok {
+					delete(h, "content-length")
+					h.Set("Transfer-Encoding", "chunked")
+					cw.terminate = true
+				}
+				// the encoded bytes differ, so a strong tag no longer holds
+				if etag, ok := h.Get("ETag"); ok && strings.HasPrefix(etag, `"`) {
+					h.Set("ETag", "W/"+etag)
+				}
+			})
+
+			next(w, req)
+
+			// a HEAD response ends with its headers, there is no chunked
+			// body to terminate
+			if cw.terminate && !head {
+				w.WriteChunkedBodyDone()
+				w.WriteTrailers(headers.NewHeaders())
+				return
+			}
+			w.CloseBody()
+		}
+	}
+}
+
+// DecodeRequests transparently decodes gzip and deflate request bodies
+// before next sees them. Bodies that would decode to more than maxSize
+// bytes, 10 MiB if maxSize <= 0, are refused with 413 Content Too Large,
+// other codings with 415 Unsupported Media Type and corrupt bodies with
+// 400 Bad Request.
+func DecodeRequests(maxSize int64) server.Middleware {
+	if maxSize <= 0 {
+		maxSize = defaultMaxDecodedSize
+	}
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			err := req.DecodeBody(maxSize)
+			switch {
+			case err == nil:
+				next(w, req)
+			case errors.Is(err, request.ErrUnsupportedEncoding):
+				h := headers.NewHeaders()
+				h.Set("Accept-Encoding", "gzip, deflate")
+				w.WriteError(response.UnsupportedMediaType, h)
+			case errors.Is(err, request.ErrBodyTooLarge):
+				w.WriteError(response.ContentTooLarge, nil)
+			default:
+				w.WriteError(response.BadRequest, nil)
+			}
+		}
+	}
+}
+
+func (cfg Config) compressible(status response.StatusCode, h headers.Headers) bool {
+	if status < 200 || status == response.NoContent || status == response.NotModified || status == response.PartialContent {
+		return false
+	}
+	if _, ok := h.Get("Content-Encoding"); ok {
+		return false
+	}
+	if contentType, ok := h.Get("Content-Type"); ok {
+		contentType = strings.ToLower(contentType)
+		for _, prefix := range compressedTypes {
+			if strings.HasPrefix(contentType, prefix) && contentType != "image/svg+xml" {
+				return false
+			}
+		}
+	}
+	if value, ok := h.Get("Content-Length"); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < cfg.MinSize {
+			return false
+		}
+	}
+	return true
+}
+
+// compressWriter passes the body through untouched until start is called
+// from the header hook, and compresses it from then on. For HEAD it
+// emits nothing unless a body is written, so a handler that sends only
+// headers gets no stray encoder framing.
+type compressWriter struct {
+	dst       io.Writer
+	enc       io.WriteCloser
+	terminate bool
+	head      bool
+	written   bool
+}
+
+func (c *compressWriter) start(encoding string, level int) error {
+	var err error
+	switch encoding {
+	case "gzip":
+		c.enc, err = gzip.NewWriterLevel(c.dst, level)
+	case "deflate":
+		// HTTP's deflate is the zlib format, not a raw deflate stream
+		c.enc, err = zlib.NewWriterLevel(c.dst, level)
+	}
+	return err
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.enc == nil {
+		return c.dst.Write(p)
+	}
+	c.written = true
+	return c.enc.Write(p)
+}
+
+func (c *compressWriter) Close() error {
+	if c.enc == nil {
+		return nil
+	}
+	if c.head && !c.written {
+		// gzip and zlib write their header lazily, so nothing went out
+		c.enc = nil
+		return nil
+	}
+	err := c.enc.Close()
+	c.enc = nil
+	return err
+}
@@ -0,0 +1,256 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/colfarl/httpfromtcp/internal/router"
+	"github.com/colfarl/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := map[string]string{
+		"":                              "",
+		"gzip":                          "gzip",
+		"deflate":                       "deflate",
+		"gzip, deflate, br":             "gzip",
+		"gzip;q=0.5, deflate":           "deflate",
+		"gzip;q=0, deflate;q=0":         "",
+		"*":                             "gzip",
+		"*;q=0.1, deflate;q=0.2":        "deflate",
+		"x-gzip":                        "gzip",
+		"br, identity":                  "",
+		"gzip;q=bogus, deflate;q=0.1":   "deflate",
+		" GZIP ; Q=0.8 , deflate;q=0.9": "deflate",
+	}
+	for header, want := range tests {
+		assert.Equal(t, want, Negotiate(header), header)
+	}
+}
+
+func serve(t *testing.T, h server.Handler, acceptEncoding string) (headers.Headers, []byte) {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	if acceptEncoding != "" {
+		raw += "Accept-Encoding: " + acceptEncoding + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	server.Chain(h, Middleware(Config{MinSize: 64}))(&w, req)
+
+	r := bufio.NewReader(&out)
+	_, err = r.ReadString('\n')
+	require.NoError(t, err)
+	respHeaders := headers.NewHeaders()
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(line), ": ")
+		respHeaders.Set(key, value)
+	}
+	if te, _ := respHeaders.Get("Transfer-Encoding"); te != "chunked" {
+		body, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return respHeaders, body
+	}
+	var body []byte
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			break
+		}
+		chunk := make([]byte, size+2)
+		_, err = io.ReadFull(r, chunk)
+		require.NoError(t, err)
+		body = append(body, chunk[:size]...)
+	}
+	rest, _ := io.ReadAll(r)
+	assert.Equal(t, "\r\n", string(rest))
+	return respHeaders, body
+}
+
+func fixed(contentType, body string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("Content-Type", contentType)
+		h.Set("ETag", `"v1"`)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	page := strings.Repeat("<p>hello compression</p>\n", 20)
+
+	// Test: Fixed-length response becomes chunked gzip
+	h, body := serve(t, fixed("text/html", page), "gzip, deflate")
+	get := func(key string) string { v, _ := h.Get(key); return v }
+	assert.Equal(t, "gzip", get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", get("Vary"))
+	assert.Equal(t, `W/"v1"`, get("ETag"))
+	_, hasLength := h.Get("Content-Length")
+	assert.False(t, hasLength)
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(decoded))
+	assert.Less(t, len(body), len(page))
+
+	// Test: Deflate uses the zlib format
+	h, body = serve(t, fixed("text/html", page), "deflate")
+	assert.Equal(t, "deflate", get("Content-Encoding"))
+	zlr, err := zlib.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zlr)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(decoded))
+
+	// Test: Client without Accept-Encoding still gets Vary
+	h, body = serve(t, fixed("text/html", page), "")
+	assert.Equal(t, page, string(body))
+	assert.Equal(t, "Accept-Encoding", get("Vary"))
+	_, encoded := h.Get("Content-Encoding")
+	assert.False(t, encoded)
+
+	// Test: Tiny bodies are left alone
+	h, body = serve(t, fixed("text/html", "tiny"), "gzip")
+	assert.Equal(t, "tiny", string(body))
+	assert.Equal(t, "4", get("Content-Length"))
+
+	// Test: Compressed types are left alone
+	h, body = serve(t, fixed("image/png", page), "gzip")
+	assert.Equal(t, page, string(body))
+	_, encoded = h.Get("Content-Encoding")
+	assert.False(t, encoded)
+
+	// Test: Chunked response with trailers
+	h, body = serve(t, func(w *response.Writer, req *request.Request) {
+		rh := headers.NewHeaders()
+		rh.Set("Content-Type", "application/json")
+		rh.Set("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(rh)
+		for i := 0; i < 10; i++ {
+			w.WriteChunkedBody([]byte(`{"n":` + strconv.Itoa(i) + "}\n"))
+		}
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(headers.NewHeaders())
+	}, "gzip")
+	assert.Equal(t, "gzip", get("Content-Encoding"))
+	zr, err = gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(decoded), "{\"n\":0}\n{\"n\":1}\n"))
+	assert.True(t, strings.HasSuffix(string(decoded), "{\"n\":9}\n"))
+}
+
+func TestMiddlewareHead(t *testing.T) {
+	page := strings.Repeat("<p>hello compression</p>\n", 20)
+	run := func(h server.Handler, method string) (*response.Response, string) {
+		req, err := request.RequestFromReader(strings.NewReader(method + " / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n"))
+		require.NoError(t, err)
+		var out bytes.Buffer
+		w := response.NewWriter(&out)
+		server.Chain(h, Middleware(Config{MinSize: 64}))(&w, req)
+		raw := out.String()
+		resp, err := response.ResponseFromReader(&out, method)
+		require.NoError(t, err)
+		return resp, raw
+	}
+	compare := func(h server.Handler) {
+		t.Helper()
+		get, _ := run(h, "GET")
+		head, raw := run(h, "HEAD")
+		assert.Equal(t, get.StatusLine, head.StatusLine)
+		assert.Equal(t, get.Headers, head.Headers)
+		te, _ := head.Headers.Get("Transfer-Encoding")
+		assert.Equal(t, "chunked", te)
+		assert.True(t, strings.HasSuffix(raw, "\r\n\r\n"), raw)
+		assert.NotContains(t, raw, "\r\n0\r\n")
+	}
+
+	// Test: HEAD through the router gets the headers GET does, and the
+	// router drops the body
+	rt := router.New()
+	require.NoError(t, rt.Handle("GET /", fixed("text/html", page)))
+	compare(rt.Handler())
+
+	// Test: A handler answering HEAD itself gets the same headers, with
+	// no encoder output or chunk terminator after them
+	compare(func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(page))
+		h.Set("Content-Type", "text/html")
+		h.Set("ETag", `"v1"`)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		if req.RequestLine.Method != "HEAD" {
+			w.WriteBody([]byte(page))
+		}
+	})
+}
+
+func TestDecodeRequests(t *testing.T) {
+	var received string
+	echo := func(w *response.Writer, req *request.Request) {
+		received = string(req.Body)
+		w.WriteError(response.OK, nil)
+	}
+	handler := server.Chain(echo, DecodeRequests(64))
+	send := func(encoding string, body []byte) string {
+		raw := "POST / HTTP/1.1\r\nContent-Encoding: " + encoding + "\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body)
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		var out bytes.Buffer
+		w := response.NewWriter(&out)
+		handler(&w, req)
+		return out.String()
+	}
+	gzipped := func(s string) []byte {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write([]byte(s))
+		zw.Close()
+		return b.Bytes()
+	}
+
+	// Test: Decoded before the handler runs
+	out := send("gzip", gzipped("hello"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, "hello", received)
+
+	// Test: Zip bomb is refused
+	out = send("gzip", gzipped(strings.Repeat("a", 1000)))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large\r\n"))
+
+	// Test: Unknown coding
+	out = send("br", []byte("abc"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 415 Unsupported Media Type\r\n"))
+	assert.Contains(t, out, "accept-encoding: gzip, deflate\r\n")
+
+	// Test: Corrupt body
+	out = send("gzip", []byte("garbage"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+}
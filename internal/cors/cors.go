@@ -0,0 +1,210 @@
+// Package cors lets browsers call the server from pages served by other
+// origins, see the Fetch standard's CORS protocol
+package cors
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/colfarl/httpfromtcp/internal/server"
+)
+
+// Config describes which cross-origin requests are allowed
+type Config struct {
+	// AllowedOrigins lists the origins that may make requests, each an
+	// exact origin such as "https://example.com", a pattern with "*"
+	// wildcards such as "https://*.example.com", or "*" for any origin
+	AllowedOrigins []string
+	// AllowedOriginPatterns are regular expressions an origin may match
+	// instead; they should be anchored
+	AllowedOriginPatterns []*regexp.Regexp
+
+	// AllowedMethods are the methods allowed cross-origin, GET, HEAD and
+	// POST by default
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed beyond the safelisted
+	// ones browsers always send; "*" allows any
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read beyond the
+	// safelisted ones
+	ExposedHeaders []string
+
+	// AllowCredentials lets requests carry cookies and authorization.
+	// Browsers then reject a wildcard origin, so the request's own origin
+	// is echoed even when "*" is allowed.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight result; zero leaves
+	// it to the browser
+	MaxAge time.Duration
+}
+
+var defaultMethods = []string{"GET", "HEAD", "POST"}
+
+// Middleware adds CORS headers to responses for allowed origins and
+// answers their preflight requests with 204 No Content. Preflights that
+// ask for an origin, method or header that is not allowed get 403. Unless
+// every origin gets the wildcard, each response carries Vary: Origin,
+// since whether it has CORS headers depends on the Origin it was sent.
+func Middleware(cfg Config) server.Middleware {
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = defaultMethods
+	}
+	p := &policy{cfg: cfg}
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			p.anyOrigin = true
+		} else if strings.Contains(origin, "*") {
+			p.patterns = append(p.patterns, wildcardPattern(origin))
+		} else {
+			p.origins = append(p.origins, strings.ToLower(origin))
+		}
+	}
+	p.patterns = append(p.patterns, cfg.AllowedOriginPatterns...)
+	for _, name := range cfg.AllowedHeaders {
+		if name == "*" {
+			p.anyHeader = true
+		}
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.RequestTarget == "*" {
+				next(w, req)
+				return
+			}
+			origin, ok := req.Headers.Get("Origin")
+			_, requested := req.Headers.Get("Access-Control-Request-Method")
+			if ok && req.RequestLine.Method == "OPTIONS" && requested {
+				p.preflight(w, req, origin)
+				return
+			}
+			allowed := ok && p.allowOrigin(origin)
+			if allowed || p.varies() {
+				w.OnHeaders(func(_ response.StatusCode, h headers.Headers) {
+					if p.varies() {
+						h.AddVary("Origin")
+					}
+					if !allowed {
+						return
+					}
+					p.setOrigin(h, origin)
+					if len(cfg.ExposedHeaders) > 0 {
+						h.Set("Access-Control-Expose-Headers", strings.Join(cfg.ExposedHeaders, ", "))
+					}
+				})
+			}
+			next(w, req)
+		}
+	}
+}
+
+type policy struct {
+	cfg       Config
+	anyOrigin bool
+	origins   []string
+	patterns  []*regexp.Regexp
+	anyHeader bool
+}
+
+// wildcardPattern turns an origin with "*" wildcards into a regexp where
+// each "*" matches within one host label
+func wildcardPattern(origin string) *regexp.Regexp {
+	parts := strings.Split(strings.ToLower(origin), "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, "[^./:]+") + "$")
+}
+
+func (p *policy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if slices.Contains(p.origins, lower) {
+		return true
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(lower) {
+			return true
+		}
+	}
+	return false
+}
+
+// varies reports whether responses depend on the request's Origin, which
+// is so unless every origin is answered with the wildcard
+func (p *policy) varies() bool {
+	return !p.anyOrigin || p.cfg.AllowCredentials
+}
+
+// setOrigin names the origin allowed to read the response
+func (p *policy) setOrigin(h headers.Headers, origin string) {
+	if p.varies() {
+		h.Set("Access-Control-Allow-Origin", origin)
+	} else {
+		h.Set("Access-Control-Allow-Origin", "*")
+	}
+	if p.cfg.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (p *policy) preflight(w *response.Writer, req *request.Request, origin string) {
+	h := headers.NewHeaders()
+	h.AddVary("Origin")
+	h.AddVary("Access-Control-Request-Method")
+	h.AddVary("Access-Control-Request-Headers")
+
+	method, _ := req.Headers.Get("Access-Control-Request-Method")
+	requestHeaders, _ := req.Headers.Get("Access-Control-Request-Headers")
+	names := splitList(requestHeaders)
+	if !p.allowOrigin(origin) || !slices.Contains(p.cfg.AllowedMethods, strings.TrimSpace(method)) || !p.allowHeaders(names) {
+		w.WriteError(response.Forbidden, h)
+		return
+	}
+
+	p.setOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(p.cfg.AllowedMethods, ", "))
+	if len(names) > 0 {
+		// echoing the request covers "*", which browsers ignore for
+		// credentialed requests
+		h.Set("Access-Control-Allow-Headers", strings.Join(names, ", "))
+	}
+	if p.cfg.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.cfg.MaxAge.Seconds())))
+	}
+	h.Set("Connection", "close")
+	w.WriteStatusLine(response.NoContent)
+	w.WriteHeaders(h)
+}
+
+func (p *policy) allowHeaders(names []string) bool {
+	if p.anyHeader {
+		return true
+	}
+	for _, name := range names {
+		if !slices.ContainsFunc(p.cfg.AllowedHeaders, func(allowed string) bool {
+			return strings.EqualFold(allowed, name)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+func splitList(value string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	return names
+}
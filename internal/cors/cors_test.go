@@ -0,0 +1,145 @@
+package cors
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/colfarl/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, cfg Config, method string, fields ...string) (*response.Response, bool) {
+	t.Helper()
+	raw := method + " /api HTTP/1.1\r\nHost: localhost\r\n"
+	for _, field := range fields {
+		raw += field + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	called := false
+	next := func(w *response.Writer, _ *request.Request) {
+		called = true
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(2))
+		w.WriteBody([]byte("ok"))
+	}
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	server.Chain(next, Middleware(cfg))(&w, req)
+
+	resp, err := response.ResponseFromReader(&out, method)
+	require.NoError(t, err)
+	return resp, called
+}
+
+func TestMiddleware(t *testing.T) {
+	cfg := Config{
+		AllowedOrigins:        []string{"https://app.example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^http://localhost:\d+$`)},
+		AllowedMethods:        []string{"GET", "PUT"},
+		AllowedHeaders:        []string{"Content-Type", "X-Request-ID"},
+		ExposedHeaders:        []string{"X-Total-Count"},
+		AllowCredentials:      true,
+		MaxAge:                10 * time.Minute,
+	}
+
+	// Test: Simple request from an exact origin
+	resp, called := serve(t, cfg, "GET", "Origin: https://app.example.com")
+	assert.True(t, called)
+	origin, _ := resp.Headers.Get("Access-Control-Allow-Origin")
+	assert.Equal(t, "https://app.example.com", origin)
+	credentials, _ := resp.Headers.Get("Access-Control-Allow-Credentials")
+	assert.Equal(t, "true", credentials)
+	exposed, _ := resp.Headers.Get("Access-Control-Expose-Headers")
+	assert.Equal(t, "X-Total-Count", exposed)
+	vary, _ := resp.Headers.Get("Vary")
+	assert.Equal(t, "Origin", vary)
+
+	// Test: Wildcard and regexp origins
+	resp, _ = serve(t, cfg, "GET", "Origin: https://shop.example.org")
+	origin, _ = resp.Headers.Get("Access-Control-Allow-Origin")
+	assert.Equal(t, "https://shop.example.org", origin)
+	resp, _ = serve(t, cfg, "GET", "Origin: https://a.b.example.org")
+	_, ok := resp.Headers.Get("Access-Control-Allow-Origin")
+	assert.False(t, ok)
+	resp, _ = serve(t, cfg, "GET", "Origin: http://localhost:5173")
+	origin, _ = resp.Headers.Get("Access-Control-Allow-Origin")
+	assert.Equal(t, "http://localhost:5173", origin)
+
+	// Test: Disallowed origin still reaches the handler, without CORS
+	// headers but varying on Origin
+	resp, called = serve(t, cfg, "GET", "Origin: https://evil.example.net")
+	assert.True(t, called)
+	_, ok = resp.Headers.Get("Access-Control-Allow-Origin")
+	assert.False(t, ok)
+	vary, _ = resp.Headers.Get("Vary")
+	assert.Equal(t, "Origin", vary)
+
+	// Test: Preflight is answered without calling the handler
+	resp, called = serve(t, cfg, "OPTIONS",
+		"Origin: https://app.example.com",
+		"Access-Control-Request-Method: PUT",
+		"Access-Control-Request-Headers: content-type, x-request-id")
+	assert.False(t, called)
+	assert.Equal(t, response.NoContent, resp.StatusLine.StatusCode)
+	origin, _ = resp.Headers.Get("Access-Control-Allow-Origin")
+	assert.Equal(t, "https://app.example.com", origin)
+	methods, _ := resp.Headers.Get("Access-Control-Allow-Methods")
+	assert.Equal(t, "GET, PUT", methods)
+	allowed, _ := resp.Headers.Get("Access-Control-Allow-Headers")
+	assert.Equal(t, "content-type, x-request-id", allowed)
+	maxAge, _ := resp.Headers.Get("Access-Control-Max-Age")
+	assert.Equal(t, "600", maxAge)
+	vary, _ = resp.Headers.Get("Vary")
+	assert.Equal(t, "Origin, Access-Control-Request-Method, Access-Control-Request-Headers", vary)
+
+	// Test: Preflights asking for too much are refused
+	resp, called = serve(t, cfg, "OPTIONS", "Origin: https://app.example.com", "Access-Control-Request-Method: DELETE")
+	assert.False(t, called)
+	assert.Equal(t, response.Forbidden, resp.StatusLine.StatusCode)
+	resp, _ = serve(t, cfg, "OPTIONS", "Origin: https://app.example.com", "Access-Control-Request-Method: GET", "Access-Control-Request-Headers: X-Secret")
+	assert.Equal(t, response.Forbidden, resp.StatusLine.StatusCode)
+	resp, _ = serve(t, cfg, "OPTIONS", "Origin: https://evil.example.net", "Access-Control-Request-Method: GET")
+	assert.Equal(t, response.Forbidden, resp.StatusLine.StatusCode)
+
+	// Test: Plain OPTIONS and same-origin requests pass through
+	_, called = serve(t, cfg, "OPTIONS", "Origin: https://app.example.com")
+	assert.True(t, called)
+	resp, called = serve(t, cfg, "GET")
+	assert.True(t, called)
+	_, ok = resp.Headers.Get("Access-Control-Allow-Origin")
+	assert.False(t, ok)
+	vary, _ = resp.Headers.Get("Vary")
+	assert.Equal(t, "Origin", vary)
+}
+
+func TestMiddlewareAnyOrigin(t *testing.T) {
+	// Test: Any origin without credentials uses the wildcard
+	cfg := Config{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}}
+	resp, _ := serve(t, cfg, "GET", "Origin: https://anywhere.test")
+	origin, _ := resp.Headers.Get("Access-Control-Allow-Origin")
+	assert.Equal(t, "*", origin)
+	_, ok := resp.Headers.Get("Vary")
+	assert.False(t, ok)
+
+	// Test: Any header is allowed and echoed, default methods apply
+	resp, _ = serve(t, cfg, "OPTIONS", "Origin: https://anywhere.test", "Access-Control-Request-Method: POST", "Access-Control-Request-Headers: X-Anything")
+	assert.Equal(t, response.NoContent, resp.StatusLine.StatusCode)
+	allowed, _ := resp.Headers.Get("Access-Control-Allow-Headers")
+	assert.Equal(t, "x-anything", allowed)
+	methods, _ := resp.Headers.Get("Access-Control-Allow-Methods")
+	assert.Equal(t, "GET, HEAD, POST", methods)
+
+	// Test: Credentials force the origin to be echoed
+	cfg.AllowCredentials = true
+	resp, _ = serve(t, cfg, "GET", "Origin: https://anywhere.test")
+	origin, _ = resp.Headers.Get("Access-Control-Allow-Origin")
+	assert.Equal(t, "https://anywhere.test", origin)
+}
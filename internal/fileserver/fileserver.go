@@ -0,0 +1,228 @@
+// Package fileserver serves files from a directory tree
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/colfarl/httpfromtcp/internal/server"
+)
+
+const indexFile = "index.html"
+
+// Config describes the tree to serve
+type Config struct {
+	// Root is the directory files are served from. Nothing outside it can
+	// be reached, not even through symlinks.
+	Root string
+	// StripPrefix is removed from the request path before it is looked up
+	// under Root, e.g. "/static" to serve Root at /static/
+	StripPrefix string
+	// Listing renders an HTML index for directories without index.html;
+	// otherwise those are Forbidden
+	Listing bool
+}
+
+// New returns a handler that serves the files under cfg.Root
+func New(cfg Config) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		name, ok := resolve(req.RequestLine.RequestTarget, cfg.StripPrefix)
+		if !ok {
+			w.WriteError(response.NotFound, nil)
+			return
+		}
+
+		root, err := os.OpenRoot(cfg.Root)
+		if err != nil {
+			w.WriteError(response.InternalError, nil)
+			return
+		}
+		defer root.Close()
+
+		f, err := root.Open(relative(name))
+		if err != nil {
+			writeOpenError(w, err)
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			writeOpenError(w, err)
+			return
+		}
+		if !info.IsDir() {
+			serveFile(w, req, f, info)
+			return
+		}
+
+		// directories are only served with a trailing slash so that
+		// relative links inside them resolve correctly
+		if !strings.HasSuffix(name, "/") {
+			redirect(w, cfg.StripPrefix+name+"/")
+			return
+		}
+
+		index, err := root.Open(relative(path.Join(name, indexFile)))
+		if err == nil {
+			defer index.Close()
+			if indexInfo, err := index.Stat(); err == nil && !indexInfo.IsDir() {
+				serveFile(w, req, index, indexInfo)
+				return
+			}
+		}
+		if !cfg.Listing {
+			w.WriteError(response.Forbidden, nil)
+			return
+		}
+		serveListing(w, f, cfg.StripPrefix+name)
+	}
+}
+
+// ServeFile serves the single file at name, answering 404 or 403 rather
+// than failing when it is missing or unreadable
+func ServeFile(w *response.Writer, req *request.Request, name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	if info.IsDir() {
+		w.WriteError(response.Forbidden, nil)
+		return
+	}
+	serveFile(w, req, f, info)
+}
+
+// resolve turns a request target into a clean slash-rooted path with the
+// prefix removed. Cleaning collapses any "..", so the result never climbs
+// above "/"; os.Root then guards against symlinks that point outside.
+func resolve(target, prefix string) (string, bool) {
+	if i := strings.IndexByte(target, '?'); i != -1 {
+		target = target[:i]
+	}
+	p, err := url.PathUnescape(target)
+	if err != nil || strings.ContainsRune(p, 0) {
+		return "", false
+	}
+
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" {
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			return "", false
+		}
+		p = strings.TrimPrefix(p, prefix)
+	}
+
+	trailing := strings.HasSuffix(p, "/")
+	p = path.Clean("/" + p)
+	if trailing && p != "/" {
+		p += "/"
+	}
+	return p, true
+}
+
+func relative(name string) string {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// writeOpenError answers 404 for missing files and 403 for everything
+// else, which covers permission errors as well as the plain errors os.Root
+// reports for symlinks pointing outside the tree
+func writeOpenError(w *response.Writer, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		w.WriteError(response.NotFound, nil)
+		return
+	}
+	w.WriteError(response.Forbidden, nil)
+}
+
+func redirect(w *response.Writer, location string) {
+	h := headers.NewHeaders()
+	h.Set("Location", (&url.URL{Path: location}).EscapedPath())
+	w.WriteError(response.MovedPermanently, h)
+}
+
+func serveFile(w *response.Writer, req *request.Request, f *os.File, info fs.FileInfo) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		w.WriteError(response.InternalError, nil)
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		w.WriteError(response.InternalError, nil)
+		return
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", contentType(info.Name(), head[:n]))
+	h.Set("Last-Modified", info.ModTime().UTC().Format(response.TimeFormat))
+	// like most servers the tag is derived from the file's metadata, which
+	// is cheap and changes whenever the file is rewritten
+	h.Set("ETag", response.StrongETag(strconv.FormatInt(info.ModTime().UnixNano(), 16)+"-"+strconv.FormatInt(info.Size(), 16)))
+	h.Set("Connection", "close")
+	response.ServeContent(w, req, h, f)
+}
+
+func serveListing(w *response.Writer, dir *os.File, urlPath string) {
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		w.WriteError(response.InternalError, nil)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	title := html.EscapeString(urlPath)
+	var b strings.Builder
+	fmt.Fprintf(&b, "<html>\n  <head>\n    <title>Index of %s</title>\n  </head>\n  <body>\n    <h1>Index of %s</h1>\n    <ul>\n", title, title)
+	if urlPath != "/" {
+		b.WriteString("      <li><a href=\"../\">../</a></li>\n")
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		href := (&url.URL{Path: name}).EscapedPath()
+		// a colon in the first segment would otherwise read as a scheme
+		if strings.Contains(strings.SplitN(name, "/", 2)[0], ":") {
+			href = "./" + href
+		}
+		fmt.Fprintf(&b, "      <li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	b.WriteString("    </ul>\n  </body>\n</html>\n")
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(b.Len()))
+	h.Set("Connection", "close")
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(b.String()))
+}
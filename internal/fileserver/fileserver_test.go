@@ -0,0 +1,153 @@
+package fileserver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/colfarl/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, h server.Handler, target string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader("GET " + target + " HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	h(&w, req)
+	return buf.String()
+}
+
+func setupTree(t *testing.T) (string, string) {
+	t.Helper()
+	base := t.TempDir()
+	root := filepath.Join(base, "public")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "docs"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "site"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "private"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "hello.txt"), []byte("hello world"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "noext"), []byte("\x89PNG\r\n\x1a\nrest"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "a <b>.md"), []byte("# doc"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "site", "index.html"), []byte("<h1>home</h1>"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "secret.txt"), []byte("secret"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(base, "secret.txt"), filepath.Join(root, "escape.txt")))
+	return root, base
+}
+
+func TestFileServer(t *testing.T) {
+	root, _ := setupTree(t)
+	h := New(Config{Root: root, StripPrefix: "/static", Listing: true})
+
+	// Test: Regular file with type from extension
+	out := get(t, h, "/static/hello.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, out, "content-length: 11\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello world"))
+
+	// Test: Type sniffed from content
+	out = get(t, h, "/static/noext")
+	assert.Contains(t, out, "content-type: image/png\r\n")
+
+	// Test: Index resolution
+	out = get(t, h, "/static/site/")
+	assert.True(t, strings.HasSuffix(out, "<h1>home</h1>"))
+
+	// Test: Directories redirect to their trailing slash form
+	out = get(t, h, "/static/site")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, out, "location: /static/site/\r\n")
+
+	// Test: Directory listing escapes names
+	out = get(t, h, "/static/docs/")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, `<a href="a%20%3Cb%3E.md">a &lt;b&gt;.md</a>`)
+	assert.Contains(t, out, `<a href="../">../</a>`)
+
+	// Test: Missing files
+	out = get(t, h, "/static/missing.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+	out = get(t, h, "/elsewhere/hello.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Path traversal stays inside the root
+	out = get(t, h, "/static/../secret.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+	out = get(t, h, "/static/%2e%2e/%2e%2e/secret.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Symlinks out of the root are forbidden
+	out = get(t, h, "/static/escape.txt")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden\r\n"))
+
+	// Test: Listing disabled
+	h = New(Config{Root: root})
+	out = get(t, h, "/private/")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden\r\n"))
+}
+
+func TestServeFile(t *testing.T) {
+	root, _ := setupTree(t)
+
+	// Test: Existing file
+	out := get(t, func(w *response.Writer, req *request.Request) {
+		ServeFile(w, req, filepath.Join(root, "hello.txt"))
+	}, "/")
+	assert.True(t, strings.HasSuffix(out, "hello world"))
+
+	// Test: Missing file
+	out = get(t, func(w *response.Writer, req *request.Request) {
+		ServeFile(w, req, filepath.Join(root, "missing.mp4"))
+	}, "/")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+}
+
+func TestSniff(t *testing.T) {
+	assert.Equal(t, "text/html; charset=utf-8", sniff([]byte("  <!DOCTYPE html><html>")))
+	assert.Equal(t, "application/pdf", sniff([]byte("%PDF-1.7")))
+	assert.Equal(t, "video/mp4", sniff([]byte("\x00\x00\x00\x20ftypisom")))
+	assert.Equal(t, "image/webp", sniff([]byte("RIFF\x00\x00\x00\x00WEBPVP8 ")))
+	assert.Equal(t, "text/plain; charset=utf-8", sniff([]byte("plain text\n")))
+	assert.Equal(t, "application/octet-stream", sniff([]byte{0x00, 0x01, 0x02}))
+}
+
+func TestFileServerConditional(t *testing.T) {
+	root, _ := setupTree(t)
+	h := New(Config{Root: root})
+
+	out := get(t, h, "/hello.txt")
+	_, etag, ok := strings.Cut(out, "etag: ")
+	require.True(t, ok)
+	etag, _, _ = strings.Cut(etag, "\r\n")
+	_, lastModified, ok := strings.Cut(out, "last-modified: ")
+	require.True(t, ok)
+	lastModified, _, _ = strings.Cut(lastModified, "\r\n")
+
+	send := func(extra string) string {
+		req, err := request.RequestFromReader(strings.NewReader("GET /hello.txt HTTP/1.1\r\n" + extra + "\r\n"))
+		require.NoError(t, err)
+		buf := &bytes.Buffer{}
+		w := response.NewWriter(buf)
+		h(&w, req)
+		return buf.String()
+	}
+
+	// Test: Revalidating with the ETag
+	out = send("If-None-Match: " + etag + "\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+
+	// Test: Revalidating with the date
+	out = send("If-Modified-Since: " + lastModified + "\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+
+	// Test: Range guarded by If-Range
+	out = send("Range: bytes=0-4\r\nIf-Range: " + etag + "\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+}
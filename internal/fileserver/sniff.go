@@ -0,0 +1,98 @@
+package fileserver
+
+import (
+	"bytes"
+	"mime"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of a file is inspected when its extension does not
+// give away its type
+const sniffLen = 512
+
+type signature struct {
+	offset   int
+	magic    []byte
+	mimeType string
+}
+
+var signatures = []signature{
+	{0, []byte("%PDF-"), "application/pdf"},
+	{0, []byte("\x89PNG\r\n\x1a\n"), "image/png"},
+	{0, []byte("\xff\xd8\xff"), "image/jpeg"},
+	{0, []byte("GIF87a"), "image/gif"},
+	{0, []byte("GIF89a"), "image/gif"},
+	{0, []byte("PK\x03\x04"), "application/zip"},
+	{0, []byte("\x1f\x8b\x08"), "application/gzip"},
+	{0, []byte("\x1a\x45\xdf\xa3"), "video/webm"},
+	{0, []byte("OggS"), "application/ogg"},
+	{0, []byte("ID3"), "audio/mpeg"},
+	{0, []byte("\x00asm"), "application/wasm"},
+	{4, []byte("ftyp"), "video/mp4"},
+}
+
+var riffTypes = map[string]string{
+	"WEBP": "image/webp",
+	"WAVE": "audio/wave",
+	"AVI ": "video/avi",
+}
+
+var htmlPrefixes = []string{"<!doctype html", "<html", "<head", "<body", "<!--", "<title", "<script", "<div", "<p"}
+
+// contentType picks a MIME type from name's extension, falling back to
+// sniffing the leading bytes of the file
+func contentType(name string, head []byte) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return sniff(head)
+}
+
+func sniff(head []byte) string {
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+	for _, sig := range signatures {
+		if len(head) >= sig.offset+len(sig.magic) && bytes.Equal(head[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return sig.mimeType
+		}
+	}
+	// RIFF containers name their format after the chunk size
+	if len(head) >= 12 && string(head[:4]) == "RIFF" {
+		if t, ok := riffTypes[string(head[8:12])]; ok {
+			return t
+		}
+	}
+
+	text := strings.ToLower(strings.TrimLeft(string(head), " \t\r\n\ufeff"))
+	for _, prefix := range htmlPrefixes {
+		if strings.HasPrefix(text, prefix) {
+			return "text/html; charset=utf-8"
+		}
+	}
+	if strings.HasPrefix(text, "<?xml") {
+		return "text/xml; charset=utf-8"
+	}
+	if isText(head) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// isText reports whether head looks like UTF-8 text, tolerating a rune cut
+// off at the end of the sample
+func isText(head []byte) bool {
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size <= 1 {
+			return len(head) < utf8.UTFMax && !utf8.FullRune(head)
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' {
+			return false
+		}
+		head = head[size:]
+	}
+	return true
+}
@@ -44,6 +44,21 @@ func containsOnlyValidTokens(fieldName string) bool {
 	return true
 }
 
+// IsToken reports whether s is a non-empty RFC 9110 token
+func IsToken(s string) bool {
+	return s != "" && containsOnlyValidTokens(s)
+}
+
+// IsCookieOctet reports whether b may appear in a cookie value, see the
+// cookie-octet rule of RFC 6265 4.1.1
+func IsCookieOctet(b byte) bool {
+	return b == 0x21 ||
+		(b >= 0x23 && b <= 0x2B) ||
+		(b >= 0x2D && b <= 0x3A) ||
+		(b >= 0x3C && b <= 0x5B) ||
+		(b >= 0x5D && b <= 0x7E)
+}
+
 func (h Headers) Get(key string) (string, bool) {
 	key = strings.ToLower(key)	
 	v, ok := h[key]
@@ -55,6 +70,23 @@ func (h Headers) Set(key, value string) {
 	h[key] = value
 }
 
+// AddVary appends field to the Vary header unless it is already covered,
+// for responses that depend on that request header
+func (h Headers) AddVary(field string) {
+	vary, ok := h.Get("Vary")
+	if !ok || strings.TrimSpace(vary) == "" {
+		h.Set("Vary", field)
+		return
+	}
+	for _, existing := range strings.Split(vary, ",") {
+		existing = strings.TrimSpace(existing)
+		if existing == "*" || strings.EqualFold(existing, field) {
+			return
+		}
+	}
+	h.Set("Vary", vary+", "+field)
+}
+
 func (h Headers) Parse(data []byte) (n int, done bool, err error){
 	idx := bytes.Index(data, []byte(crlf))
 	if idx == -1 {
@@ -65,8 +97,8 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error){
 		return len(crlf), true, nil
 	}
 	
-	colonIndex := bytes.Index(data, []byte(":"))
-	if colonIndex == -1 {
+	colonIndex := bytes.Index(data[:idx], []byte(":"))
+	if colonIndex < 1 {
 		return 0, false, fmt.Errorf("invalid field line syntax")
 	}
 	splitData := []string{string(data[:colonIndex]), string(data[colonIndex + 1:idx])}
@@ -76,14 +108,14 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error){
 	}
 	
 	unparsedKey := strings.Fields(splitData[0])
-	unparsedValue := strings.Fields(splitData[1])
-	
-	if len(unparsedKey) > 1 || len(unparsedValue) > 1 {
+	if len(unparsedKey) != 1 {
 		return 0, false,  fmt.Errorf("invalid field line syntax") 
 	}
 	
+	// field values may contain internal whitespace, only the surrounding
+	// optional whitespace is dropped
 	key := unparsedKey[0]
-	value := unparsedValue[0]
+	value := strings.TrimSpace(splitData[1])
 	
 	key = strings.ToLower(key)
 	if !containsOnlyValidTokens(key) || len(key) <= 1 {
@@ -39,6 +39,15 @@ func TestHeadersRead(t *testing.T) {
 	assert.Equal(t, 25, n)
 	assert.False(t, done)
 
+	// Test: Valid header value with internal whitespace
+	headers = NewHeaders()
+	data = []byte("Cookie:  session=abc; theme=dark  \r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, "session=abc; theme=dark", headers["cookie"])
+	assert.Equal(t, 36, n)
+	assert.False(t, done)
+
 	// Test: Valid done
 	headers = NewHeaders()
 	data = []byte("\r\n a bunch of other stuff")
@@ -93,4 +102,28 @@ func TestHeadersRead(t *testing.T) {
 
 }
 
+func TestAddVary(t *testing.T) {
+	// Test: Fields are appended once, ignoring case
+	h := NewHeaders()
+	h.AddVary("Origin")
+	h.AddVary("Accept-Encoding")
+	h.AddVary("accept-encoding")
+	v, _ := h.Get("Vary")
+	assert.Equal(t, "Origin, Accept-Encoding", v)
+
+	// Test: A wildcard already covers everything
+	h.Set("Vary", "*")
+	h.AddVary("Origin")
+	v, _ = h.Get("Vary")
+	assert.Equal(t, "*", v)
+}
 
+func TestIsCookieOctet(t *testing.T) {
+	// Test: Separators, quotes, whitespace and controls are excluded
+	for _, b := range []byte("abcXYZ019!#$%&'()*+-./:<=>?@[]^_`{|}~") {
+		assert.True(t, IsCookieOctet(b), string(b))
+	}
+	for _, b := range []byte(" \",;\\\x00\x7f\r\n") {
+		assert.False(t, IsCookieOctet(b), string(b))
+	}
+}
@@ -0,0 +1,406 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/colfarl/httpfromtcp/internal/server"
+)
+
+// Strategy decides which backend serves a request
+type Strategy int
+
+const (
+	// RoundRobin takes the backends in turn
+	RoundRobin Strategy = iota
+	// LeastConnections picks the backend with the fewest requests in flight
+	LeastConnections
+	// ConsistentHash sends requests with the same key to the same backend,
+	// moving as few keys as possible when backends come and go
+	ConsistentHash
+)
+
+// PoolConfig describes a set of interchangeable backends
+type PoolConfig struct {
+	// Targets are the backend base URLs, see Config.Target
+	Targets []string
+	// Proxy holds the settings shared by every backend; its Target is
+	// ignored
+	Proxy Config
+
+	Strategy Strategy
+	// HashHeader keys ConsistentHash by the value of this request header,
+	// falling back to the client's IP when it is empty or absent
+	HashHeader string
+
+	// HealthCheckPath enables active health checks: every
+	// HealthCheckInterval, 10 seconds by default, each backend is sent a
+	// GET for this path and is taken out of rotation until it answers with
+	// a 2xx or 3xx within HealthCheckTimeout, 2 seconds by default
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+
+	// MaxFails is how many consecutive failed requests eject a backend,
+	// 3 by default. Without active health checks an ejected backend is
+	// tried again after EjectTimeout, 30 seconds by default.
+	MaxFails     int
+	EjectTimeout time.Duration
+
+	// MaxAttempts bounds how many backends an idempotent request is tried
+	// on when they fail before responding, 2 by default
+	MaxAttempts int
+}
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+	defaultMaxFails            = 3
+	defaultEjectTimeout        = 30 * time.Second
+	defaultMaxAttempts         = 2
+	// hashReplicas is how many points each backend gets on the hash ring,
+	// which evens out the share of keys each one receives
+	hashReplicas = 100
+)
+
+var errNoBackend = errors.New("no backend available")
+
+type backend struct {
+	target *url.URL
+	active atomic.Int64
+
+	// guarded by Pool.mu
+	healthy      bool
+	fails        int
+	ejectedUntil time.Time
+}
+
+type ringPoint struct {
+	hash    uint32
+	backend *backend
+}
+
+// Pool load balances requests over several backends
+type Pool struct {
+	cfg      PoolConfig
+	proxy    *Proxy
+	backends []*backend
+	ring     []ringPoint
+	next     atomic.Uint64
+	now      func() time.Time
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewPool(cfg PoolConfig) (*Pool, error) {
+	if len(cfg.Targets) == 0 {
+		return nil, fmt.Errorf("proxy pool needs at least one target")
+	}
+	if cfg.HealthCheckInterval <= 0 {
+		cfg.HealthCheckInterval = defaultHealthCheckInterval
+	}
+	if cfg.HealthCheckTimeout <= 0 {
+		cfg.HealthCheckTimeout = defaultHealthCheckTimeout
+	}
+	if cfg.MaxFails <= 0 {
+		cfg.MaxFails = defaultMaxFails
+	}
+	if cfg.EjectTimeout <= 0 {
+		cfg.EjectTimeout = defaultEjectTimeout
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+
+	p := &Pool{
+		cfg:   cfg,
+		proxy: &Proxy{cfg: cfg.Proxy.withDefaults()},
+		now:   time.Now,
+		done:  make(chan struct{}),
+	}
+	for _, raw := range cfg.Targets {
+		target, err := parseTarget(raw)
+		if err != nil {
+			return nil, err
+		}
+		b := &backend{target: target, healthy: true}
+		p.backends = append(p.backends, b)
+		for i := 0; i < hashReplicas; i++ {
+			p.ring = append(p.ring, ringPoint{hashKey(target.String() + "#" + strconv.Itoa(i)), b})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool {
+		return p.ring[i].hash < p.ring[j].hash
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	if cfg.HealthCheckPath != "" {
+		go p.healthChecks(ctx)
+	} else {
+		close(p.done)
+	}
+	return p, nil
+}
+
+// Close stops the active health checks
+func (p *Pool) Close() {
+	p.cancel()
+	<-p.done
+}
+
+// Healthy returns the targets currently in rotation
+func (p *Pool) Healthy() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	healthy := make([]string, 0, len(p.backends))
+	for _, b := range p.backends {
+		if p.available(b, now) {
+			healthy = append(healthy, b.target.String())
+		}
+	}
+	return healthy
+}
+
+// Handler returns a handler that forwards each request to a backend
+// chosen by the strategy. Idempotent requests that fail before anything
+// reached the client are retried on a different backend.
+func (p *Pool) Handler() server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		attempts := 1
+		if idempotent(req.RequestLine.Method) {
+			attempts = p.cfg.MaxAttempts
+		}
+
+		var err error = &upstreamError{errNoBackend}
+		tried := make(map[*backend]bool)
+		for i := 0; i < attempts; i++ {
+			b := p.pick(req, tried)
+			if b == nil {
+				break
+			}
+			tried[b] = true
+
+			b.active.Add(1)
+			err = p.proxy.forward(w, req, b.target)
+			b.active.Add(-1)
+			p.report(req.Context(), b, err)
+
+			var ue *upstreamError
+			if err == nil || !errors.As(err, &ue) || req.Context().Err() != nil {
+				break
+			}
+		}
+		if err != nil {
+			writeUpstreamError(w, req, err)
+		}
+	}
+}
+
+// idempotent reports whether repeating a request has the same effect as
+// sending it once, see RFC 9110 9.2.2
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// available reports whether b may receive requests. An ejected backend
+// comes back once its timeout passes unless health checks manage it.
+func (p *Pool) available(b *backend, now time.Time) bool {
+	if b.healthy {
+		return true
+	}
+	return p.cfg.HealthCheckPath == "" && !now.Before(b.ejectedUntil)
+}
+
+// pick chooses a backend that has not been tried yet, preferring healthy
+// ones. When every untried backend is ejected they are all considered
+// rather than failing outright.
+func (p *Pool) pick(req *request.Request, tried map[*backend]bool) *backend {
+	p.mu.Lock()
+	now := p.now()
+	candidates := make(map[*backend]bool)
+	for _, b := range p.backends {
+		if !tried[b] && p.available(b, now) {
+			candidates[b] = true
+		}
+	}
+	if len(candidates) == 0 {
+		for _, b := range p.backends {
+			if !tried[b] {
+				candidates[b] = true
+			}
+		}
+	}
+	p.mu.Unlock()
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	switch p.cfg.Strategy {
+	case LeastConnections:
+		return p.leastConnections(candidates)
+	case ConsistentHash:
+		return p.consistentHash(req, candidates)
+	default:
+		return p.roundRobin(candidates)
+	}
+}
+
+func (p *Pool) roundRobin(candidates map[*backend]bool) *backend {
+	start := int(p.next.Add(1) - 1)
+	for i := range p.backends {
+		b := p.backends[(start+i)%len(p.backends)]
+		if candidates[b] {
+			return b
+		}
+	}
+	return nil
+}
+
+// leastConnections picks the least busy candidate, starting the scan at a
+// rotating offset so ties are spread out
+func (p *Pool) leastConnections(candidates map[*backend]bool) *backend {
+	start := int(p.next.Add(1) - 1)
+	var best *backend
+	for i := range p.backends {
+		b := p.backends[(start+i)%len(p.backends)]
+		if candidates[b] && (best == nil || b.active.Load() < best.active.Load()) {
+			best = b
+		}
+	}
+	return best
+}
+
+// consistentHash walks the ring clockwise from the key's hash to the first
+// candidate, so a key only moves when its backend leaves rotation
+func (p *Pool) consistentHash(req *request.Request, candidates map[*backend]bool) *backend {
+	key := clientIP(req)
+	if p.cfg.HashHeader != "" {
+		if value, ok := req.Headers.Get(p.cfg.HashHeader); ok && value != "" {
+			key = value
+		}
+	}
+	h := hashKey(key)
+	start := sort.Search(len(p.ring), func(i int) bool {
+		return p.ring[i].hash >= h
+	})
+	for i := range p.ring {
+		point := p.ring[(start+i)%len(p.ring)]
+		if candidates[point.backend] {
+			return point.backend
+		}
+	}
+	return nil
+}
+
+func hashKey(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+// report records the outcome of forwarding to b. Only failures to get a
+// response count; a client that went away says nothing about b.
+func (p *Pool) report(ctx context.Context, b *backend, err error) {
+	var ue *upstreamError
+	failed := err != nil && errors.As(err, &ue) && !errors.Is(ctx.Err(), context.Canceled)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !failed {
+		b.fails = 0
+		if p.cfg.HealthCheckPath == "" {
+			b.healthy = true
+		}
+		return
+	}
+	b.fails++
+	if b.fails >= p.cfg.MaxFails {
+		b.healthy = false
+		b.ejectedUntil = p.now().Add(p.cfg.EjectTimeout)
+	}
+}
+
+func (p *Pool) healthChecks(ctx context.Context) {
+	defer close(p.done)
+	ticker := time.NewTicker(p.cfg.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		p.checkAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pool) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.probe(ctx, b.target)
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			b.healthy = err == nil
+			if b.healthy {
+				b.fails = 0
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// probe sends a health check request to target
+func (p *Pool) probe(ctx context.Context, target *url.URL) error {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.HealthCheckTimeout)
+	defer cancel()
+	conn, err := p.proxy.dial(ctx, target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	path := strings.TrimSuffix(target.EscapedPath(), "/") + "/" + strings.TrimPrefix(p.cfg.HealthCheckPath, "/")
+	h := headers.NewHeaders()
+	h.Set("Host", target.Host)
+	h.Set("Connection", "close")
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: path, HttpVersion: "1.1"},
+		Headers:     h,
+	}
+	if _, err := req.WriteTo(conn); err != nil {
+		return err
+	}
+	// only the status matters, the body is never read
+	resp, err := response.ResponseHeadFromReader(bufio.NewReaderSize(conn, readBufferSize), "GET")
+	if err != nil {
+		return err
+	}
+	if status := resp.StatusLine.StatusCode; status < 200 || status >= 400 {
+		return fmt.Errorf("health check status %d", status)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func named(t *testing.T, name string) string {
+	t.Helper()
+	target, _ := upstream(t, func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\n" + name
+	})
+	return target
+}
+
+func deadTarget(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l.Close()
+	return "http://" + l.Addr().String()
+}
+
+func poolRequest(t *testing.T, p *Pool, raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = "203.0.113.7:5555"
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	p.Handler()(&w, req)
+	return out.String()
+}
+
+func body(out string) string {
+	_, b, _ := strings.Cut(out, "\r\n\r\n")
+	return b
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	p, err := NewPool(PoolConfig{Targets: []string{named(t, "a"), named(t, "b"), named(t, "c")}})
+	require.NoError(t, err)
+	defer p.Close()
+
+	got := ""
+	for i := 0; i < 6; i++ {
+		got += body(poolRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	}
+	assert.Equal(t, "abcabc", got)
+}
+
+func TestPoolLeastConnections(t *testing.T) {
+	p, err := NewPool(PoolConfig{Targets: []string{named(t, "a"), named(t, "b")}, Strategy: LeastConnections})
+	require.NoError(t, err)
+	defer p.Close()
+
+	// Test: Busy backends are avoided
+	p.backends[0].active.Add(3)
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "b", body(poolRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")))
+	}
+	p.backends[0].active.Add(-3)
+	p.backends[1].active.Add(1)
+	assert.Equal(t, "a", body(poolRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")))
+}
+
+func TestPoolConsistentHash(t *testing.T) {
+	p, err := NewPool(PoolConfig{
+		Targets:    []string{named(t, "a"), named(t, "b"), named(t, "c")},
+		Strategy:   ConsistentHash,
+		HashHeader: "X-User",
+	})
+	require.NoError(t, err)
+	defer p.Close()
+
+	// Test: The same key sticks to one backend
+	seen := map[string]string{}
+	for i := 0; i < 30; i++ {
+		user := "user" + string(rune('a'+i%10))
+		got := body(poolRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\nX-User: "+user+"\r\n\r\n"))
+		if prior, ok := seen[user]; ok {
+			assert.Equal(t, prior, got)
+		}
+		seen[user] = got
+	}
+
+	// Test: Keys spread over the backends
+	backends := map[string]bool{}
+	for _, b := range seen {
+		backends[b] = true
+	}
+	assert.Greater(t, len(backends), 1)
+
+	// Test: Without the header the client IP is the key
+	first := body(poolRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, first, body(poolRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")))
+	}
+}
+
+func TestPoolFailover(t *testing.T) {
+	dead := deadTarget(t)
+	p, err := NewPool(PoolConfig{Targets: []string{dead, named(t, "b")}, MaxFails: 2, EjectTimeout: time.Hour})
+	require.NoError(t, err)
+	defer p.Close()
+
+	// Test: Idempotent requests are retried on another backend
+	out := poolRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, "b", body(out))
+	assert.Len(t, p.Healthy(), 2)
+
+	// Test: Other requests are not
+	out = poolRequest(t, p, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 502 Bad Gateway\r\n"))
+
+	// Test: Consecutive failures eject the backend
+	assert.Equal(t, []string{strings.TrimSuffix(p.cfg.Targets[1], "/")}, p.Healthy())
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "b", body(poolRequest(t, p, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n")))
+	}
+
+	// Test: Ejected backends return after the timeout
+	p.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	assert.Len(t, p.Healthy(), 2)
+
+	// Test: Nothing reachable is a 502
+	p, err = NewPool(PoolConfig{Targets: []string{dead}})
+	require.NoError(t, err)
+	defer p.Close()
+	out = poolRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 502 Bad Gateway\r\n"))
+}
+
+func TestPoolHealthChecks(t *testing.T) {
+	var sickened atomic.Bool
+	sick, seen := upstream(t, func(req *request.Request) string {
+		if req.RequestLine.RequestTarget == "/api/healthz" && sickened.Load() {
+			return "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n"
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\ns"
+	})
+	p, err := NewPool(PoolConfig{
+		Targets:             []string{sick + "/api", named(t, "b")},
+		HealthCheckPath:     "/healthz",
+		HealthCheckInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer p.Close()
+
+	// Test: Probes hit the configured path under the target's path
+	req := <-seen
+	assert.Equal(t, "/api/healthz", req.RequestLine.RequestTarget)
+
+	sickened.Store(true)
+	require.Eventually(t, func() bool { return len(p.Healthy()) == 1 }, time.Second, 5*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "b", body(poolRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")))
+	}
+
+	// Test: Only the status of a probe is read, not its body
+	huge, _ := upstream(t, func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 1000000000\r\n\r\npartial"
+	})
+	target, err := parseTarget(huge)
+	require.NoError(t, err)
+	assert.NoError(t, p.probe(context.Background(), target))
+}
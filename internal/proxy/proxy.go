@@ -0,0 +1,338 @@
+// Package proxy forwards requests to an upstream HTTP server
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/colfarl/httpfromtcp/internal/server"
+)
+
+// Config describes the upstream and how requests are rewritten for it
+type Config struct {
+	// Target is the upstream base URL, e.g. "http://127.0.0.1:8080" or
+	// "https://httpbin.org/api". Its path is prepended to forwarded paths.
+	Target string
+	// StripPrefix is removed from the request path before it is appended to
+	// the target's path
+	StripPrefix string
+	// RewriteHost sends the target's host as Host instead of the client's
+	RewriteHost bool
+	// TLSConfig is used for https targets, the system roots by default
+	TLSConfig *tls.Config
+
+	// DialTimeout bounds connecting to the upstream, 10 seconds by default
+	DialTimeout time.Duration
+	// ResponseTimeout bounds waiting for the upstream's response headers
+	// once the request is sent, 30 seconds by default
+	ResponseTimeout time.Duration
+}
+
+const (
+	defaultDialTimeout     = 10 * time.Second
+	defaultResponseTimeout = 30 * time.Second
+	// readBufferSize bounds the length of each line of the upstream's
+	// response head
+	readBufferSize = 64 << 10
+)
+
+// hopHeaders describe a single connection and must not be forwarded, see
+// RFC 9110 7.6.1
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Proxy forwards requests to a single upstream
+type Proxy struct {
+	cfg    Config
+	target *url.URL
+}
+
+func New(cfg Config) (*Proxy, error) {
+	target, err := parseTarget(cfg.Target)
+	if err != nil {
+		return nil, err
+	}
+	return &Proxy{cfg: cfg.withDefaults(), target: target}, nil
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = defaultDialTimeout
+	}
+	if cfg.ResponseTimeout <= 0 {
+		cfg.ResponseTimeout = defaultResponseTimeout
+	}
+	return cfg
+}
+
+func parseTarget(raw string) (*url.URL, error) {
+	target, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy target %q: %w", raw, err)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, fmt.Errorf("invalid proxy target %q: scheme must be http or https", raw)
+	}
+	if target.Host == "" {
+		return nil, fmt.Errorf("invalid proxy target %q: missing host", raw)
+	}
+	return target, nil
+}
+
+// Handler returns a handler that forwards every request to the upstream,
+// answering 502 Bad Gateway when the upstream cannot be reached or sends
+// something unusable and 504 Gateway Timeout when it is too slow
+func (p *Proxy) Handler() server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if err := p.forward(w, req, p.target); err != nil {
+			writeUpstreamError(w, req, err)
+		}
+	}
+}
+
+// upstreamError is a failure before anything was sent to the client, so
+// the request can still be answered or retried elsewhere
+type upstreamError struct {
+	err error
+}
+
+func (e *upstreamError) Error() string {
+	return "upstream: " + e.err.Error()
+}
+
+func (e *upstreamError) Unwrap() error {
+	return e.err
+}
+
+func writeUpstreamError(w *response.Writer, req *request.Request, err error) {
+	var ue *upstreamError
+	if !errors.As(err, &ue) {
+		// the response was already under way, nothing more can be said
+		return
+	}
+	if isTimeout(req.Context(), err) {
+		w.WriteError(response.GatewayTimeout, nil)
+		return
+	}
+	w.WriteError(response.BadGateway, nil)
+}
+
+func isTimeout(ctx context.Context, err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// forward sends req to target and relays the response to w. It returns an
+// *upstreamError if nothing has been written to w yet.
+func (p *Proxy) forward(w *response.Writer, req *request.Request, target *url.URL) error {
+	ctx := req.Context()
+	conn, err := p.dial(ctx, target)
+	if err != nil {
+		return &upstreamError{err}
+	}
+	defer conn.Close()
+	// closing the connection unblocks any read or write once the client
+	// goes away or the request deadline passes
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(p.cfg.ResponseTimeout))
+	if err := p.writeRequest(conn, req, target); err != nil {
+		return &upstreamError{err}
+	}
+
+	br := bufio.NewReaderSize(conn, readBufferSize)
+	resp, err := response.ResponseHeadFromReader(br, req.RequestLine.Method)
+	if err != nil {
+		return &upstreamError{err}
+	}
+	conn.SetDeadline(time.Time{})
+
+	return relay(w, resp)
+}
+
+func (p *Proxy) dial(ctx context.Context, target *url.URL) (net.Conn, error) {
+	addr := target.Host
+	if target.Port() == "" {
+		if target.Scheme == "https" {
+			addr = net.JoinHostPort(target.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(target.Hostname(), "80")
+		}
+	}
+	dialer := &net.Dialer{Timeout: p.cfg.DialTimeout}
+	if target.Scheme != "https" {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	cfg := p.cfg.TLSConfig
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = target.Hostname()
+	}
+	return (&tls.Dialer{NetDialer: dialer, Config: cfg}).DialContext(ctx, "tcp", addr)
+}
+
+// upstreamTarget maps the client's request target onto the upstream,
+// keeping the query string
+func (p *Proxy) upstreamTarget(requestTarget string, target *url.URL) string {
+	path, query, hasQuery := strings.Cut(requestTarget, "?")
+	if prefix := strings.TrimSuffix(p.cfg.StripPrefix, "/"); prefix != "" {
+		path = strings.TrimPrefix(path, prefix)
+	}
+	path = strings.TrimSuffix(target.EscapedPath(), "/") + "/" + strings.TrimPrefix(path, "/")
+	if hasQuery {
+		path += "?" + query
+	}
+	return path
+}
+
+func (p *Proxy) writeRequest(conn net.Conn, req *request.Request, target *url.URL) error {
+	out := headers.NewHeaders()
+	for key, value := range req.Headers {
+		out.Set(key, value)
+	}
+	removeHopHeaders(out)
+
+	host, _ := req.Headers.Get("Host")
+	if p.cfg.RewriteHost || host == "" {
+		out.Set("Host", target.Host)
+	}
+	addForwarded(out, req, host)
+	out.Set("Connection", "close")
+
+	upstream := *req
+	upstream.RequestLine.RequestTarget = p.upstreamTarget(req.RequestLine.RequestTarget, target)
+	upstream.Headers = out
+	_, err := upstream.WriteTo(conn)
+	return err
+}
+
+// removeHopHeaders drops the hop-by-hop headers, including any the
+// Connection header names
+func removeHopHeaders(h headers.Headers) {
+	if connection, ok := h.Get("Connection"); ok {
+		for _, name := range strings.Split(connection, ",") {
+			delete(h, strings.ToLower(strings.TrimSpace(name)))
+		}
+	}
+	for _, name := range hopHeaders {
+		delete(h, strings.ToLower(name))
+	}
+}
+
+// clientIP returns the IP address of the client, or "" when it connected
+// over a transport without one, such as a Unix socket
+func clientIP(req *request.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return ip
+}
+
+// addForwarded appends the client to X-Forwarded-For and Forwarded
+func addForwarded(h headers.Headers, req *request.Request, host string) {
+	ip := clientIP(req)
+	if ip != "" {
+		if prior, ok := h.Get("X-Forwarded-For"); ok && prior != "" {
+			h.Set("X-Forwarded-For", prior+", "+ip)
+		} else {
+			h.Set("X-Forwarded-For", ip)
+		}
+	}
+
+	node := "unknown"
+	if ip != "" {
+		node = ip
+		if strings.Contains(ip, ":") {
+			node = `"[` + ip + `]"`
+		}
+	}
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	element := "for=" + node + ";proto=" + proto
+	if host != "" {
+		element += ";host=" + quoteForwarded(host)
+	}
+	if prior, ok := h.Get("Forwarded"); ok && prior != "" {
+		element = prior + ", " + element
+	}
+	h.Set("Forwarded", element)
+}
+
+// quoteForwarded quotes a Forwarded parameter value unless it is a token
+func quoteForwarded(value string) string {
+	if headers.IsToken(value) {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// relay writes the upstream response to w, streaming the body. A body
+// whose length the upstream did not declare is sent chunked. Each
+// Set-Cookie line is kept on its own, since cookies cannot be joined.
+func relay(w *response.Writer, resp *response.Response) error {
+	out := headers.NewHeaders()
+	for key, value := range resp.Headers {
+		out.Set(key, value)
+	}
+	removeHopHeaders(out)
+	out.Set("Connection", "close")
+	delete(out, "set-cookie")
+	for _, cookie := range resp.Values("Set-Cookie") {
+		if err := w.SetCookieLine(cookie); err != nil {
+			return &upstreamError{err}
+		}
+	}
+
+	length := resp.ContentLength()
+	if length < 0 {
+		delete(out, "content-length")
+		out.Set("Transfer-Encoding", "chunked")
+	}
+
+	if err := w.WriteStatusLineReason(resp.StatusLine.StatusCode, resp.StatusLine.ReasonPhrase); err != nil {
+		return &upstreamError{err}
+	}
+	w.WriteHeaders(out)
+	if length == 0 {
+		return nil
+	}
+	if _, err := io.Copy(w, resp.BodyReader()); err != nil {
+		return err
+	}
+	if length < 0 {
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(resp.Trailers)
+	}
+	return nil
+}
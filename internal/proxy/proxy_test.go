@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upstream accepts connections and answers each request with reply,
+// reporting the requests it saw on the returned channel
+func upstream(t *testing.T, reply func(req *request.Request) string) (string, <-chan *request.Request) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	seen := make(chan *request.Request, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := request.RequestFromReader(conn)
+				if err != nil {
+					return
+				}
+				select {
+				case seen <- req:
+				default:
+				}
+				conn.Write([]byte(reply(req)))
+			}()
+		}
+	}()
+	return "http://" + l.Addr().String(), seen
+}
+
+func proxyRequest(t *testing.T, p *Proxy, raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = "203.0.113.7:5555"
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	p.Handler()(&w, req)
+	return out.String()
+}
+
+func TestProxyForwarding(t *testing.T) {
+	target, seen := upstream(t, func(req *request.Request) string {
+		return "HTTP/1.1 201 Created\r\nContent-Length: 2\r\nKeep-Alive: timeout=5\r\nX-Upstream: yes\r\n\r\nok"
+	})
+	p, err := New(Config{Target: target + "/api", StripPrefix: "/proxy"})
+	require.NoError(t, err)
+
+	out := proxyRequest(t, p, "POST /proxy/items?x=1 HTTP/1.1\r\nHost: example.com\r\nConnection: close, X-Secret\r\nX-Secret: hop\r\nX-Forwarded-For: 198.51.100.1\r\nContent-Length: 5\r\n\r\nhello")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 201 Created\r\n"))
+	assert.Contains(t, out, "x-upstream: yes\r\n")
+	assert.NotContains(t, out, "keep-alive")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nok"))
+
+	// Test: Method, path, body and headers reach the upstream
+	req := <-seen
+	assert.Equal(t, "POST", req.RequestLine.Method)
+	assert.Equal(t, "/api/items?x=1", req.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(req.Body))
+	host, _ := req.Headers.Get("Host")
+	assert.Equal(t, "example.com", host)
+	_, ok := req.Headers.Get("X-Secret")
+	assert.False(t, ok)
+	xff, _ := req.Headers.Get("X-Forwarded-For")
+	assert.Equal(t, "198.51.100.1, 203.0.113.7", xff)
+	forwarded, _ := req.Headers.Get("Forwarded")
+	assert.Equal(t, "for=203.0.113.7;proto=http;host=example.com", forwarded)
+
+	// Test: Host rewriting
+	p, err = New(Config{Target: target, RewriteHost: true})
+	require.NoError(t, err)
+	proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	req = <-seen
+	host, _ = req.Headers.Get("Host")
+	assert.Equal(t, strings.TrimPrefix(target, "http://"), host)
+}
+
+func TestProxyCookies(t *testing.T) {
+	target, _ := upstream(t, func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\nSet-Cookie: a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT\r\nContent-Length: 0\r\nSet-Cookie: b=2\r\n\r\n"
+	})
+	p, err := New(Config{Target: target})
+	require.NoError(t, err)
+
+	// Test: Each cookie keeps its own line, in order
+	out := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Contains(t, out, "Set-Cookie: a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT\r\nSet-Cookie: b=2\r\n")
+	assert.NotContains(t, out, "set-cookie")
+}
+
+func TestProxyStreaming(t *testing.T) {
+	// Test: Chunked upstream with trailers is relayed chunked
+	target, _ := upstream(t, func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n"
+	})
+	p, err := New(Config{Target: target})
+	require.NoError(t, err)
+	out := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\nx-checksum: abc\r\n\r\n"))
+
+	// Test: Close-delimited upstream is relayed chunked
+	target, _ = upstream(t, func(req *request.Request) string {
+		return "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil close"
+	})
+	p, err = New(Config{Target: target})
+	require.NoError(t, err)
+	out = proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nb\r\nuntil close\r\n0\r\n\r\n"))
+
+	// Test: Interim responses are skipped and unknown statuses relayed
+	target, _ = upstream(t, func(req *request.Request) string {
+		return "HTTP/1.1 103 Early Hints\r\nLink: </a.css>\r\n\r\nHTTP/1.1 418 I'm a teapot\r\nContent-Length: 0\r\n\r\n"
+	})
+	p, err = New(Config{Target: target})
+	require.NoError(t, err)
+	out = proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 418 I'm a teapot\r\n"))
+}
+
+func TestProxyFailures(t *testing.T) {
+	// Test: Unreachable upstream is a 502
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	p, err := New(Config{Target: "http://" + addr})
+	require.NoError(t, err)
+	out := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 502 Bad Gateway\r\n"))
+
+	// Test: Garbage from the upstream is a 502
+	target, _ := upstream(t, func(req *request.Request) string {
+		return "SSH-2.0-OpenSSH\r\n\r\n"
+	})
+	p, err = New(Config{Target: target})
+	require.NoError(t, err)
+	out = proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 502 Bad Gateway\r\n"))
+
+	// Test: Slow upstream is a 504
+	target, _ = upstream(t, func(req *request.Request) string {
+		time.Sleep(500 * time.Millisecond)
+		return "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+	})
+	p, err = New(Config{Target: target, ResponseTimeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+	out = proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 504 Gateway Timeout\r\n"))
+
+	// Test: Request deadline is a 504 as well
+	p, err = New(Config{Target: target})
+	require.NoError(t, err)
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	p.Handler()(&w, req.WithContext(ctx))
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 504 Gateway Timeout\r\n"))
+
+	// Test: Invalid targets
+	_, err = New(Config{Target: "ftp://example.com"})
+	assert.Error(t, err)
+	_, err = New(Config{Target: "http://"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,202 @@
+// Package ratelimit throttles clients with per-key token buckets
+package ratelimit
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/colfarl/httpfromtcp/internal/server"
+)
+
+// Config describes the bucket every key gets
+type Config struct {
+	// Rate is how many requests per second a key regains, one by default
+	Rate float64
+	// Burst is the bucket size, the most requests a key can make at once
+	Burst int
+	// MaxKeys bounds memory, 10000 by default. Once it is reached a new
+	// key takes the place of the least recently used bucket if that one
+	// is idle, refilled to Burst; otherwise the new key is refused until
+	// it is, so fresh keys cannot push out busy ones and reset them. Only
+	// that one bucket is checked, keeping the cost per request constant.
+	// The trade-off is a lockout: clients spread over more than MaxKeys
+	// keys that keep their buckets busy shut out every new client, so
+	// MaxKeys should comfortably exceed the clients expected at once.
+	MaxKeys int
+	// Key groups requests into buckets, RemoteIP by default
+	Key func(req *request.Request) string
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// Limiter tracks one token bucket per key
+type Limiter struct {
+	cfg Config
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	lru     *list.List
+}
+
+const defaultMaxKeys = 10000
+
+func New(cfg Config) *Limiter {
+	if cfg.Rate <= 0 {
+		cfg.Rate = 1
+	}
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = defaultMaxKeys
+	}
+	if cfg.Key == nil {
+		cfg.Key = RemoteIP
+	}
+	return &Limiter{
+		cfg:     cfg,
+		now:     time.Now,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// RemoteIP keys requests by the client's IP address
+func RemoteIP(req *request.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// HeaderKey keys requests by the value of a header such as an API key,
+// falling back to RemoteIP when the header is absent. Clients choose the
+// header's value, so one can dodge its limit by changing it; use HeaderKey
+// only behind a trusted proxy that sets or overwrites the header.
+func HeaderKey(name string) func(req *request.Request) string {
+	return func(req *request.Request) string {
+		if v, ok := req.Headers.Get(name); ok && v != "" {
+			return name + ":" + v
+		}
+		return RemoteIP(req)
+	}
+}
+
+// Decision is the outcome of taking a token
+type Decision struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next token, zero when allowed
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Allow takes a token from key's bucket if one is available
+func (l *Limiter) Allow(key string) Decision {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, wait := l.bucket(key, now)
+	if b == nil {
+		return Decision{RetryAfter: wait, Reset: wait}
+	}
+	b.tokens = l.refilled(b, now)
+	b.last = now
+
+	d := Decision{}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = l.durationFor(1 - b.tokens)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = l.durationFor(float64(l.cfg.Burst) - b.tokens)
+	return d
+}
+
+func (l *Limiter) durationFor(tokens float64) time.Duration {
+	return time.Duration(tokens / l.cfg.Rate * float64(time.Second))
+}
+
+// refilled returns the tokens b holds at now
+func (l *Limiter) refilled(b *bucket, now time.Time) float64 {
+	return math.Min(float64(l.cfg.Burst), b.tokens+now.Sub(b.last).Seconds()*l.cfg.Rate)
+}
+
+// bucket finds or creates key's bucket and marks it most recently used.
+// When there are too many it evicts the least recently used bucket if it
+// is idle; if not it returns nil and how long until it will be.
+func (l *Limiter) bucket(key string, now time.Time) (*bucket, time.Duration) {
+	if el, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(el)
+		return el.Value.(*bucket), 0
+	}
+	if l.lru.Len() >= l.cfg.MaxKeys {
+		oldest := l.lru.Back()
+		missing := float64(l.cfg.Burst) - l.refilled(oldest.Value.(*bucket), now)
+		if missing > 0 {
+			return nil, l.durationFor(missing)
+		}
+		l.lru.Remove(oldest)
+		delete(l.buckets, oldest.Value.(*bucket).key)
+	}
+	b := &bucket{key: key, tokens: float64(l.cfg.Burst), last: now}
+	l.buckets[key] = l.lru.PushFront(b)
+	return b, 0
+}
+
+// Len returns how many buckets are being tracked
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lru.Len()
+}
+
+// Middleware answers requests over the limit with 429 Too Many Requests
+// and advertises the limit on every response with RateLimit-* headers
+func (l *Limiter) Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			d := l.Allow(l.cfg.Key(req))
+			rateHeaders := headers.NewHeaders()
+			rateHeaders.Set("RateLimit-Limit", strconv.Itoa(l.cfg.Burst))
+			rateHeaders.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+			rateHeaders.Set("RateLimit-Reset", ceilSeconds(d.Reset))
+
+			if !d.Allowed {
+				rateHeaders.Set("Retry-After", ceilSeconds(d.RetryAfter))
+				w.WriteError(response.TooManyRequests, rateHeaders)
+				return
+			}
+			w.OnHeaders(func(_ response.StatusCode, h headers.Headers) {
+				for key, value := range rateHeaders {
+					if _, ok := h.Get(key); !ok {
+						h.Set(key, value)
+					}
+				}
+			})
+			next(w, req)
+		}
+	}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
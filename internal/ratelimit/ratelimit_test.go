@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestLimiter(cfg Config) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	l := New(cfg)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestAllow(t *testing.T) {
+	l, clock := newTestLimiter(Config{Rate: 2, Burst: 3})
+
+	// Test: Burst is available up front
+	for i := 2; i >= 0; i-- {
+		d := l.Allow("a")
+		require.True(t, d.Allowed)
+		assert.Equal(t, i, d.Remaining)
+	}
+
+	// Test: Empty bucket
+	d := l.Allow("a")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 500*time.Millisecond, d.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, d.Reset)
+
+	// Test: Keys have separate buckets
+	assert.True(t, l.Allow("b").Allowed)
+
+	// Test: Tokens refill at Rate
+	clock.t = clock.t.Add(500 * time.Millisecond)
+	assert.True(t, l.Allow("a").Allowed)
+	assert.False(t, l.Allow("a").Allowed)
+
+	// Test: Refill is capped at Burst
+	clock.t = clock.t.Add(time.Hour)
+	d = l.Allow("a")
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 2, d.Remaining)
+}
+
+func TestLRUEviction(t *testing.T) {
+	l, clock := newTestLimiter(Config{Rate: 1, Burst: 2, MaxKeys: 2})
+	require.True(t, l.Allow("a").Allowed)
+	require.True(t, l.Allow("b").Allowed)
+
+	// Test: A new key is refused while every bucket is busy
+	d := l.Allow("c")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, time.Second, d.RetryAfter)
+	assert.Equal(t, 2, l.Len())
+
+	// Test: Busy buckets keep their state
+	require.True(t, l.Allow("a").Allowed)
+	assert.False(t, l.Allow("a").Allowed)
+
+	// Test: The least recently used idle bucket is evicted
+	clock.t = clock.t.Add(1500 * time.Millisecond)
+	require.True(t, l.Allow("c").Allowed)
+	assert.Equal(t, 2, l.Len())
+	d = l.Allow("a")
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 0, d.Remaining)
+
+	// Test: Only the least recently used bucket is considered, even when a
+	// newer one is idle
+	l, clock = newTestLimiter(Config{Rate: 1, Burst: 2, MaxKeys: 2})
+	l.Allow("x")
+	l.Allow("x")
+	clock.t = clock.t.Add(500 * time.Millisecond)
+	l.Allow("y")
+	clock.t = clock.t.Add(time.Second)
+	d = l.Allow("z")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 500*time.Millisecond, d.RetryAfter)
+}
+
+func serve(t *testing.T, h func(w *response.Writer, req *request.Request), raw, remoteAddr string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = remoteAddr
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	h(&w, req)
+	return buf.String()
+}
+
+func TestMiddleware(t *testing.T) {
+	l, _ := newTestLimiter(Config{Rate: 1, Burst: 1})
+	h := l.Middleware()(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers.NewHeaders())
+	})
+	get := "GET / HTTP/1.1\r\n\r\n"
+
+	// Test: Allowed responses carry the limit headers
+	out := serve(t, h, get, "10.0.0.1:1111")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "ratelimit-limit: 1\r\n")
+	assert.Contains(t, out, "ratelimit-remaining: 0\r\n")
+	assert.Contains(t, out, "ratelimit-reset: 1\r\n")
+
+	// Test: Same IP, different port is the same client
+	out = serve(t, h, get, "10.0.0.1:2222")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 429 Too Many Requests\r\n"))
+	assert.Contains(t, out, "retry-after: 1\r\n")
+
+	// Test: Other clients are unaffected
+	out = serve(t, h, get, "10.0.0.2:1111")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Header keys
+	l, _ = newTestLimiter(Config{Rate: 1, Burst: 1, Key: HeaderKey("X-Api-Key")})
+	h = l.Middleware()(func(w *response.Writer, req *request.Request) {
+		w.WriteError(response.OK, nil)
+	})
+	out = serve(t, h, "GET / HTTP/1.1\r\nX-Api-Key: abc\r\n\r\n", "10.0.0.1:1111")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	out = serve(t, h, "GET / HTTP/1.1\r\nX-Api-Key: def\r\n\r\n", "10.0.0.1:1111")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	out = serve(t, h, "GET / HTTP/1.1\r\nX-Api-Key: abc\r\n\r\n", "10.0.0.9:1111")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 429 Too Many Requests\r\n"))
+}
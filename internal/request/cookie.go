@@ -0,0 +1,69 @@
+package request
+
+import (
+	"strings"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+)
+
+// Cookie is a single name/value pair sent by the client in a Cookie header
+type Cookie struct {
+	Name  string
+	Value string
+}
+
+// Cookies parses the Cookie header into its name/value pairs. Malformed
+// pairs are skipped rather than failing the whole header.
+func (r *Request) Cookies() []*Cookie {
+	raw, ok := r.Headers.Get("Cookie")
+	if !ok {
+		return nil
+	}
+	return parseCookies(raw)
+}
+
+// Cookie returns the first cookie with the given name
+func (r *Request) Cookie(name string) (*Cookie, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func parseCookies(raw string) []*Cookie {
+	cookies := make([]*Cookie, 0)
+	for _, part := range strings.Split(raw, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if !headers.IsToken(name) {
+			continue
+		}
+		value, ok = parseCookieValue(strings.TrimSpace(value))
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+func parseCookieValue(v string) (string, bool) {
+	if len(v) > 1 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+	for i := 0; i < len(v); i++ {
+		if !headers.IsCookieOctet(v[i]) {
+			return "", false
+		}
+	}
+	return v, true
+}
@@ -0,0 +1,92 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUnsupportedEncoding means the body uses a content coding that
+	// DecodeBody cannot undo
+	ErrUnsupportedEncoding = errors.New("unsupported content coding")
+	// ErrBodyTooLarge means the decoded body would exceed the size limit
+	ErrBodyTooLarge = errors.New("decoded body too large")
+)
+
+// DecodeBody undoes the gzip or deflate Content-Encoding of the body, so
+// handlers see the bytes the client meant to send. Codings are removed in
+// the reverse of the order they were applied, and the Content-Encoding and
+// Content-Length headers are updated to describe the decoded body. The
+// decoded body may be at most maxSize bytes, no limit if maxSize <= 0,
+// which stops a tiny compressed body from expanding to fill memory.
+func (r *Request) DecodeBody(maxSize int64) error {
+	value, ok := r.Headers.Get("Content-Encoding")
+	if !ok {
+		return nil
+	}
+	codings := make([]string, 0)
+	for _, coding := range strings.Split(value, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		switch coding {
+		case "", "identity":
+		case "gzip", "x-gzip", "deflate":
+			codings = append(codings, coding)
+		default:
+			return fmt.Errorf("%w: %s", ErrUnsupportedEncoding, coding)
+		}
+	}
+
+	body := r.Body
+	for i := len(codings) - 1; i >= 0; i-- {
+		decoded, err := decode(codings[i], body, maxSize)
+		if err != nil {
+			return err
+		}
+		body = decoded
+	}
+
+	r.Body = body
+	delete(r.Headers, "content-encoding")
+	r.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+func decode(coding string, body []byte, maxSize int64) ([]byte, error) {
+	var dec io.ReadCloser
+	var err error
+	switch coding {
+	case "gzip", "x-gzip":
+		dec, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// deflate should be zlib wrapped, but some clients send a raw
+		// deflate stream, so fall back to that when the header is missing
+		dec, err = zlib.NewReader(bytes.NewReader(body))
+		if errors.Is(err, zlib.ErrHeader) {
+			dec, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed %s body: %w", coding, err)
+	}
+	defer dec.Close()
+
+	var src io.Reader = dec
+	if maxSize > 0 {
+		src = io.LimitReader(dec, maxSize+1)
+	}
+	decoded, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("malformed %s body: %w", coding, err)
+	}
+	if maxSize > 0 && int64(len(decoded)) > maxSize {
+		return nil, ErrBodyTooLarge
+	}
+	return decoded, nil
+}
@@ -2,6 +2,8 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -16,8 +18,31 @@ type Request struct {
 	Headers     headers.Headers
 	Body        []byte
 
+	// RemoteAddr is the network address of the client, set by the server
+	RemoteAddr string
+	// TLS describes the connection's negotiated TLS state, including any
+	// verified client certificate chains; it is nil for plaintext requests
+	TLS *tls.ConnectionState
+	// PeerCred identifies the process on the other end of a Unix socket
+	// connection; it is nil for other transports
+	PeerCred *PeerCred
+
+	ctx            context.Context
 	state          requestState
 	bodyLengthRead int
+	pathValues     map[string]string
+	// fields lists the field lines in the order and casing they were
+	// received, for WriteTo
+	fields    []field
+	onHeaders func(*Request) error
+	buffered  []byte
+}
+
+// PeerCred holds the credentials of a local peer as reported by the kernel
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
 }
 
 type RequestLine struct {
@@ -39,12 +64,21 @@ const crlf = "\r\n"
 const bufferSize = 8
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	return ReadRequest(reader, nil)
+}
+
+// ReadRequest reads one request like RequestFromReader. onHeaders, if not
+// nil, runs once the header section is in and before any of the body is
+// read, so the request can be vetted or the client told to send its body;
+// an error from it ends the read.
+func ReadRequest(reader io.Reader, onHeaders func(*Request) error) (*Request, error) {
 	buf := make([]byte, bufferSize, bufferSize)
 	readToIndex := 0
 	req := &Request{
-		state:   requestStateInitialized,
-		Headers: headers.NewHeaders(),
-		Body:    make([]byte, 0),
+		state:     requestStateInitialized,
+		Headers:   headers.NewHeaders(),
+		Body:      make([]byte, 0),
+		onHeaders: onHeaders,
 	}
 	for req.state != requestStateDone {
 		if readToIndex >= len(buf) {
@@ -73,9 +107,73 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		copy(buf, buf[numBytesParsed:])
 		readToIndex -= numBytesParsed
 	}
+	if readToIndex > 0 {
+		req.buffered = append([]byte(nil), buf[:readToIndex]...)
+	}
 	return req, nil
 }
 
+// Buffered returns the bytes that were read from the reader past the end
+// of the request, such as the start of a pipelined request or of the
+// protocol a connection is being upgraded to
+func (r *Request) Buffered() []byte {
+	return r.buffered
+}
+
+// WantsUpgrade reports whether the client asked to switch the connection
+// to protocol, e.g. "websocket", see RFC 9110 7.8
+func (r *Request) WantsUpgrade(protocol string) bool {
+	connection, _ := r.Headers.Get("Connection")
+	upgrade, _ := r.Headers.Get("Upgrade")
+	return listContains(connection, "upgrade") && listContains(upgrade, protocol)
+}
+
+// listContains reports whether a comma separated header value contains
+// token, ignoring case and any "/version" suffix on the elements
+func listContains(value, token string) bool {
+	for _, element := range strings.Split(value, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(element), "/")
+		if strings.EqualFold(name, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Context returns the request's context. It is cancelled when the client
+// disconnects, the server shuts down or the request deadline passes.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns a shallow copy of r carrying ctx, so middleware can
+// attach values or deadlines for the handlers it wraps
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// PathValue returns the value captured for a named wildcard by a router,
+// or "" if there is none
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+// SetPathValue records a captured wildcard so PathValue can return it
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
+	}
+	r.pathValues[name] = value
+}
+
 func parseRequestLine(data []byte) (*RequestLine, int, error) {
 	idx := bytes.Index(data, []byte(crlf))
 	if idx == -1 {
@@ -162,6 +260,13 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		}
 		if done {
 			r.state = requestStateParsingBody
+			if r.onHeaders != nil {
+				if err := r.onHeaders(r); err != nil {
+					return 0, err
+				}
+			}
+		} else if n > 0 {
+			r.recordField(data[:n])
 		}
 		return n, nil
 	case requestStateParsingBody:
@@ -169,21 +274,20 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		if !ok {
 			// assume that if no content-length header is present, there is no body
 			r.state = requestStateDone
-			return len(data), nil
+			return 0, nil
 		}
 		contentLen, err := strconv.Atoi(contentLenStr)
-		if err != nil {
-			return 0, fmt.Errorf("malformed Content-Length: %s", err)
-		}
-		r.Body = append(r.Body, data...)
-		r.bodyLengthRead += len(data)
-		if r.bodyLengthRead > contentLen {
-			return 0, fmt.Errorf("Content-Length too large")
+		if err != nil || contentLen < 0 {
+			return 0, fmt.Errorf("malformed Content-Length: %s", contentLenStr)
 		}
+		// anything past the body belongs to whatever follows the request
+		n := min(len(data), contentLen-r.bodyLengthRead)
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyLengthRead += n
 		if r.bodyLengthRead == contentLen {
 			r.state = requestStateDone
 		}
-		return len(data), nil
+		return n, nil
 	case requestStateDone:
 		return 0, fmt.Errorf("error: trying to read data in a done state")
 	default:
@@ -1,9 +1,14 @@
 package request
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"testing"
 	"strings"
 	"io"
+	"strconv"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -194,3 +199,228 @@ func TestBodyParse(t *testing.T) {
 	assert.Equal(t, "", string(r.Body))
 }
 
+func TestCookiesParse(t *testing.T) {
+	// Test: Multiple cookies
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nCookie: session=abc123; theme=\"dark\"; empty=\r\n\r\n"))
+	require.NoError(t, err)
+	cookies := r.Cookies()
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.Equal(t, "abc123", cookies[0].Value)
+	assert.Equal(t, "dark", cookies[1].Value)
+	assert.Equal(t, "", cookies[2].Value)
+
+	c, ok := r.Cookie("theme")
+	require.True(t, ok)
+	assert.Equal(t, "dark", c.Value)
+	_, ok = r.Cookie("missing")
+	assert.False(t, ok)
+
+	// Test: Malformed pairs are skipped
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nCookie: novalue; bad name=1; ok=yes; bad=a\\b\r\n\r\n"))
+	require.NoError(t, err)
+	cookies = r.Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "ok", cookies[0].Name)
+
+	// Test: No Cookie header
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, r.Cookies())
+}
+
+func encodedRequest(t *testing.T, encoding string, body []byte) *Request {
+	t.Helper()
+	raw := "POST /upload HTTP/1.1\r\nContent-Encoding: " + encoding + "\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body)
+	r, err := RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return r
+}
+
+func TestDecodeBody(t *testing.T) {
+	payload := strings.Repeat("compressible payload ", 50)
+	var gz, zl, raw bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(payload))
+	gw.Close()
+	zw := zlib.NewWriter(&zl)
+	zw.Write([]byte(payload))
+	zw.Close()
+	fw, _ := flate.NewWriter(&raw, flate.DefaultCompression)
+	fw.Write([]byte(payload))
+	fw.Close()
+
+	// Test: Gzip body
+	r := encodedRequest(t, "gzip", gz.Bytes())
+	require.NoError(t, r.DecodeBody(0))
+	assert.Equal(t, payload, string(r.Body))
+	_, ok := r.Headers.Get("Content-Encoding")
+	assert.False(t, ok)
+	length, _ := r.Headers.Get("Content-Length")
+	assert.Equal(t, strconv.Itoa(len(payload)), length)
+
+	// Test: Deflate body, zlib wrapped or raw
+	r = encodedRequest(t, "deflate", zl.Bytes())
+	require.NoError(t, r.DecodeBody(0))
+	assert.Equal(t, payload, string(r.Body))
+	r = encodedRequest(t, "deflate", raw.Bytes())
+	require.NoError(t, r.DecodeBody(0))
+	assert.Equal(t, payload, string(r.Body))
+
+	// Test: Stacked codings are removed in reverse
+	var twice bytes.Buffer
+	gw = gzip.NewWriter(&twice)
+	gw.Write(zl.Bytes())
+	gw.Close()
+	r = encodedRequest(t, "deflate, gzip", twice.Bytes())
+	require.NoError(t, r.DecodeBody(0))
+	assert.Equal(t, payload, string(r.Body))
+
+	// Test: Decoded size limit
+	r = encodedRequest(t, "gzip", gz.Bytes())
+	assert.ErrorIs(t, r.DecodeBody(100), ErrBodyTooLarge)
+
+	// Test: Unsupported coding
+	r = encodedRequest(t, "br", []byte("abc"))
+	assert.ErrorIs(t, r.DecodeBody(0), ErrUnsupportedEncoding)
+
+	// Test: Corrupt body
+	r = encodedRequest(t, "gzip", []byte("not gzip at all"))
+	err := r.DecodeBody(0)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnsupportedEncoding)
+
+	// Test: No Content-Encoding leaves the body alone
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc"))
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeBody(0))
+	assert.Equal(t, "abc", string(r.Body))
+}
+
+func TestRequestWriteTo(t *testing.T) {
+	// Test: Round trip keeps header order and casing
+	raw := "POST /submit?x=1 HTTP/1.1\r\nHost: localhost:42069\r\nX-Trace-ID: abc\r\nContent-Type: text/plain\r\nx-trace-id: def\r\nContent-Length: 5\r\n\r\nhello"
+	r, err := RequestFromReader(&chunkReader{data: raw, numBytesPerRead: 3})
+	require.NoError(t, err)
+	var b bytes.Buffer
+	n, err := r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, int64(b.Len()), n)
+	assert.Equal(t, "POST /submit?x=1 HTTP/1.1\r\nHost: localhost:42069\r\nX-Trace-ID: abc\r\nContent-Type: text/plain\r\nx-trace-id: def\r\nContent-Length: 5\r\n\r\nhello", b.String())
+
+	// Test: Written request parses back the same
+	again, err := RequestFromReader(&b)
+	require.NoError(t, err)
+	assert.Equal(t, r.RequestLine, again.RequestLine)
+	assert.Equal(t, r.Headers, again.Headers)
+	assert.Equal(t, r.Body, again.Body)
+
+	// Test: Added headers follow in sorted order, removed ones are dropped,
+	// and a changed field is written once where it first appeared
+	r.Headers.Set("X-Trace-ID", "ghi")
+	r.Headers.Set("Zeta", "z")
+	r.Headers.Set("Alpha", "a")
+	delete(r.Headers, "content-type")
+	b.Reset()
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "POST /submit?x=1 HTTP/1.1\r\nHost: localhost:42069\r\nX-Trace-ID: ghi\r\nalpha: a\r\nzeta: z\r\nContent-Length: 5\r\n\r\nhello", b.String())
+
+	// Test: Repeated cookies stay on separate lines
+	raw = "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: a=1\r\nAccept: text/html\r\nCookie: b=2\r\n\r\n"
+	r, err = RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	b.Reset()
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, raw, b.String())
+
+	// Test: Invalid names and values that would inject lines are refused
+	for _, bad := range []map[string]string{
+		{"host": "example.com", "x-bad": "a\r\nInjected: yes"},
+		{"host": "example.com", "x-bad": "a\x00b"},
+		{"host": "example.com", "bad name": "a"},
+		{"host": "example.com", "bad:name": "a"},
+	} {
+		r = &Request{RequestLine: RequestLine{Method: "GET", RequestTarget: "/"}, Headers: bad}
+		b.Reset()
+		n, err = r.WriteTo(&b)
+		assert.Error(t, err)
+		assert.Zero(t, n)
+		assert.Zero(t, b.Len())
+	}
+
+	// Test: Framing follows the body, not stale headers
+	r = &Request{
+		RequestLine: RequestLine{Method: "PUT", RequestTarget: "/x"},
+		Headers:     map[string]string{"host": "example.com", "transfer-encoding": "chunked", "content-length": "99"},
+		Body:        []byte("abc"),
+	}
+	b.Reset()
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "PUT /x HTTP/1.1\r\nhost: example.com\r\ncontent-length: 3\r\n\r\nabc", b.String())
+
+	// Test: Bodiless GET declares no length
+	r = &Request{
+		RequestLine: RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"},
+		Headers:     map[string]string{"host": "example.com"},
+	}
+	b.Reset()
+	_, err = r.RequestLine.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", b.String())
+	b.Reset()
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\nhost: example.com\r\n\r\n", b.String())
+}
+
+func TestReadRequestHook(t *testing.T) {
+	raw := "POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello"
+
+	// Test: The hook sees the headers before the body is read
+	var bodyAtHook int
+	r, err := ReadRequest(&chunkReader{data: raw, numBytesPerRead: 4}, func(r *Request) error {
+		bodyAtHook = len(r.Body)
+		_, ok := r.Headers.Get("Content-Length")
+		assert.True(t, ok)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, bodyAtHook)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: An error from the hook ends the read
+	refused := io.ErrUnexpectedEOF
+	_, err = ReadRequest(&chunkReader{data: raw, numBytesPerRead: 4}, func(*Request) error {
+		return refused
+	})
+	assert.ErrorIs(t, err, refused)
+}
+
+func TestBufferedAndUpgrade(t *testing.T) {
+	// Test: Bytes past the end of a bodiless request are kept
+	r, err := RequestFromReader(&chunkReader{
+		data:            "GET /chat HTTP/1.1\r\nHost: localhost:42069\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket/13\r\n\r\nframe",
+		numBytesPerRead: 200,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "frame", string(r.Buffered()))
+	assert.True(t, r.WantsUpgrade("websocket"))
+	assert.False(t, r.WantsUpgrade("h2c"))
+
+	// Test: Bytes past the end of a body are kept, the rest left unread
+	reader := &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhelloGET / HTTP/1.1\r\n",
+		numBytesPerRead: 200,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.NotEmpty(t, r.Buffered())
+	assert.Equal(t, "GET / HTTP/1.1\r\n", string(r.Buffered())+string(rest))
+	assert.False(t, r.WantsUpgrade("websocket"))
+}
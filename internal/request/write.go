@@ -0,0 +1,140 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+)
+
+// field is one field line as it was received
+type field struct {
+	name  string
+	value string
+}
+
+// recordField remembers a parsed field line
+func (r *Request) recordField(line []byte) {
+	name, value, ok := strings.Cut(string(line), ":")
+	if !ok {
+		return
+	}
+	r.fields = append(r.fields, field{strings.TrimSpace(name), strings.TrimSpace(value)})
+}
+
+// WriteTo writes the request line in HTTP/1.1 form
+func (rl RequestLine) WriteTo(w io.Writer) (int64, error) {
+	version := rl.HttpVersion
+	if version == "" {
+		version = "1.1"
+	}
+	n, err := fmt.Fprintf(w, "%s %s HTTP/%s\r\n", rl.Method, rl.RequestTarget, version)
+	return int64(n), err
+}
+
+// WriteTo serializes the request as an HTTP/1.1 message. Headers that
+// were parsed keep their original order and casing, and come before any
+// added since, which follow in sorted order. A field received on several
+// lines is written back on those lines unless its value has changed. The
+// body is framed with a Content-Length matching Body, replacing any
+// Transfer-Encoding. Nothing is written if a field name is not a token
+// or a value contains CR, LF or NUL.
+func (r *Request) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	r.RequestLine.WriteTo(&b)
+
+	for _, f := range r.fieldLines() {
+		if !headers.IsToken(f.name) {
+			return 0, fmt.Errorf("invalid header field name %q", f.name)
+		}
+		if strings.ContainsAny(f.value, "\r\n\x00") {
+			return 0, fmt.Errorf("invalid value for header field %s", f.name)
+		}
+		fmt.Fprintf(&b, "%s: %s\r\n", f.name, f.value)
+	}
+
+	if r.needsContentLength() {
+		fmt.Fprintf(&b, "%s: %s\r\n", r.fieldName("Content-Length"), strconv.Itoa(len(r.Body)))
+	}
+	b.WriteString("\r\n")
+	b.Write(r.Body)
+
+	n, err := w.Write(b.Bytes())
+	return int64(n), err
+}
+
+// fieldLines lists the header fields to write, leaving out the framing
+// ones. A field whose value is still the one parsed keeps each of its
+// lines; one that changed is written once, where it first appeared.
+func (r *Request) fieldLines() []field {
+	received := make(map[string][]string)
+	for _, f := range r.fields {
+		key := strings.ToLower(f.name)
+		received[key] = append(received[key], f.value)
+	}
+	framing := func(key string) bool {
+		return key == "content-length" || key == "transfer-encoding"
+	}
+
+	lines := make([]field, 0, len(r.fields))
+	written := make(map[string]bool)
+	for _, f := range r.fields {
+		key := strings.ToLower(f.name)
+		value, ok := r.Headers[key]
+		if !ok || framing(key) {
+			continue
+		}
+		if value == strings.Join(received[key], ", ") {
+			lines = append(lines, f)
+		} else if !written[key] {
+			lines = append(lines, field{f.name, value})
+		}
+		written[key] = true
+	}
+	added := make([]string, 0)
+	for key := range r.Headers {
+		if !written[key] && !framing(key) {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(added)
+	for _, key := range added {
+		lines = append(lines, field{key, r.Headers[key]})
+	}
+	return lines
+}
+
+// needsContentLength reports whether the request must declare its body
+// length: when it has a body, declared one, or uses a method that
+// normally carries one, so servers need not wait for a body that isn't
+// coming
+func (r *Request) needsContentLength() bool {
+	if len(r.Body) > 0 {
+		return true
+	}
+	if _, ok := r.Headers.Get("Content-Length"); ok {
+		return true
+	}
+	if _, ok := r.Headers.Get("Transfer-Encoding"); ok {
+		return true
+	}
+	switch r.RequestLine.Method {
+	case "POST", "PUT", "PATCH":
+		return true
+	}
+	return false
+}
+
+// fieldName returns name in the casing it was received in, if it was
+func (r *Request) fieldName(name string) string {
+	for _, f := range r.fields {
+		if strings.EqualFold(f.name, name) {
+			return f.name
+		}
+	}
+	return strings.ToLower(name)
+}
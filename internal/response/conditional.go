@@ -0,0 +1,166 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+)
+
+// StrongETag quotes opaque as a strong entity tag, which promises the
+// representation is byte-for-byte identical whenever the tag matches.
+// Characters not allowed in an entity tag are dropped.
+func StrongETag(opaque string) string {
+	return `"` + etagOpaque(opaque) + `"`
+}
+
+// WeakETag quotes opaque as a weak entity tag, which only promises the
+// representation is semantically equivalent whenever the tag matches
+func WeakETag(opaque string) string {
+	return "W/" + StrongETag(opaque)
+}
+
+// HashETag derives a strong entity tag from the representation itself
+func HashETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return StrongETag(hex.EncodeToString(sum[:16]))
+}
+
+func etagOpaque(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '"' || r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+type etag struct {
+	weak   bool
+	opaque string
+}
+
+func parseETag(s string) (etag, bool) {
+	tags, ok := parseETagList(s)
+	if !ok || len(tags) != 1 {
+		return etag{}, false
+	}
+	return tags[0], true
+}
+
+// parseETagList splits a comma separated list of entity tags. Commas are
+// legal inside a tag, so the quotes have to be followed rather than
+// splitting on every comma.
+func parseETagList(s string) ([]etag, bool) {
+	tags := make([]etag, 0)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return tags, true
+		}
+		tag := etag{}
+		if strings.HasPrefix(s, "W/") {
+			tag.weak = true
+			s = s[2:]
+		}
+		if !strings.HasPrefix(s, `"`) {
+			return nil, false
+		}
+		end := strings.IndexByte(s[1:], '"')
+		if end == -1 {
+			return nil, false
+		}
+		tag.opaque = s[1 : end+1]
+		tags = append(tags, tag)
+		s = s[end+2:]
+	}
+}
+
+// matchETags reports whether current is in the If-Match or If-None-Match
+// list, using the strong or weak comparison function of RFC 9110 8.8.3.2
+func matchETags(list string, current etag, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	tags, ok := parseETagList(list)
+	if !ok {
+		return false
+	}
+	for _, tag := range tags {
+		if tag.opaque != current.opaque {
+			continue
+		}
+		if !strong || (!tag.weak && !current.weak) {
+			return true
+		}
+	}
+	return false
+}
+
+// notModifiedFields are the representation headers a 304 must repeat
+// from the 200 it stands in for
+var notModifiedFields = []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Last-Modified", "Vary", "Connection"}
+
+// CheckPreconditions evaluates If-Match, If-Unmodified-Since,
+// If-None-Match and If-Modified-Since in the order RFC 9110 13.2.2 gives
+// against the ETag and Last-Modified in h, the headers the handler would
+// send with a full response. When a condition fails it answers with 304
+// Not Modified or 412 Precondition Failed and returns true, and the
+// handler should not write anything further.
+func CheckPreconditions(w *Writer, req *request.Request, h headers.Headers) bool {
+	method := req.RequestLine.Method
+	safe := method == "GET" || method == "HEAD"
+
+	current, hasETag := etag{}, false
+	if value, ok := h.Get("ETag"); ok {
+		current, hasETag = parseETag(value)
+	}
+	lastModified, hasLastModified := time.Time{}, false
+	if value, ok := h.Get("Last-Modified"); ok {
+		t, err := time.Parse(TimeFormat, value)
+		lastModified, hasLastModified = t, err == nil
+	}
+
+	if ifMatch, ok := req.Headers.Get("If-Match"); ok {
+		if strings.TrimSpace(ifMatch) != "*" && (!hasETag || !matchETags(ifMatch, current, true)) {
+			w.WriteError(PreconditionFailed, nil)
+			return true
+		}
+	} else if ifUnmodified, ok := req.Headers.Get("If-Unmodified-Since"); ok && hasLastModified {
+		if since, err := time.Parse(TimeFormat, ifUnmodified); err == nil && lastModified.After(since) {
+			w.WriteError(PreconditionFailed, nil)
+			return true
+		}
+	}
+
+	if ifNoneMatch, ok := req.Headers.Get("If-None-Match"); ok {
+		if strings.TrimSpace(ifNoneMatch) == "*" || (hasETag && matchETags(ifNoneMatch, current, false)) {
+			if safe {
+				writeNotModified(w, h)
+			} else {
+				w.WriteError(PreconditionFailed, nil)
+			}
+			return true
+		}
+	} else if ifModified, ok := req.Headers.Get("If-Modified-Since"); ok && safe && hasLastModified {
+		if since, err := time.Parse(TimeFormat, ifModified); err == nil && !lastModified.After(since) {
+			writeNotModified(w, h)
+			return true
+		}
+	}
+	return false
+}
+
+func writeNotModified(w *Writer, h headers.Headers) {
+	out := headers.NewHeaders()
+	for _, field := range notModifiedFields {
+		if value, ok := h.Get(field); ok {
+			out.Set(field, value)
+		}
+	}
+	w.WriteStatusLine(NotModified)
+	w.WriteHeaders(out)
+}
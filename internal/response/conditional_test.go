@@ -0,0 +1,112 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETags(t *testing.T) {
+	// Test: Strong and weak tags
+	assert.Equal(t, `"abc"`, StrongETag("abc"))
+	assert.Equal(t, `W/"abc"`, WeakETag("abc"))
+
+	// Test: Invalid characters are dropped
+	assert.Equal(t, `"abc"`, StrongETag(`a"b c`))
+
+	// Test: Hash tags follow the content
+	assert.Equal(t, HashETag([]byte("hello")), HashETag([]byte("hello")))
+	assert.NotEqual(t, HashETag([]byte("hello")), HashETag([]byte("world")))
+
+	// Test: Lists may contain commas inside tags
+	tags, ok := parseETagList(`"a,b", W/"c" ,"d"`)
+	require.True(t, ok)
+	assert.Equal(t, []etag{{opaque: "a,b"}, {weak: true, opaque: "c"}, {opaque: "d"}}, tags)
+
+	// Test: Strong comparison rejects weak tags, weak comparison does not
+	assert.False(t, matchETags(`W/"c"`, etag{opaque: "c"}, true))
+	assert.True(t, matchETags(`W/"c"`, etag{opaque: "c"}, false))
+}
+
+func checkPreconditions(t *testing.T, method string, reqHeaders map[string]string) (bool, string) {
+	t.Helper()
+	raw := method + " /doc HTTP/1.1\r\nHost: localhost\r\n"
+	for k, v := range reqHeaders {
+		raw += k + ": " + v + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	h := headers.NewHeaders()
+	h.Set("ETag", `"v2"`)
+	h.Set("Last-Modified", "Wed, 02 Jan 2030 15:04:05 GMT")
+	h.Set("Content-Type", "text/plain")
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	done := CheckPreconditions(&w, req, h)
+	return done, out.String()
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	const before = "Tue, 01 Jan 2030 15:04:05 GMT"
+	const modified = "Wed, 02 Jan 2030 15:04:05 GMT"
+
+	// Test: No conditions
+	done, out := checkPreconditions(t, "GET", nil)
+	assert.False(t, done)
+	assert.Empty(t, out)
+
+	// Test: If-None-Match hit on GET is 304 with validators only
+	done, out = checkPreconditions(t, "GET", map[string]string{"If-None-Match": `"v1", W/"v2"`})
+	assert.True(t, done)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, out, "etag: \"v2\"\r\n")
+	assert.NotContains(t, out, "content-type")
+
+	// Test: If-None-Match hit on POST is 412
+	done, out = checkPreconditions(t, "POST", map[string]string{"If-None-Match": "*"})
+	assert.True(t, done)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 412 Precondition Failed\r\n"))
+
+	// Test: If-None-Match miss takes precedence over If-Modified-Since
+	done, _ = checkPreconditions(t, "GET", map[string]string{"If-None-Match": `"v1"`, "If-Modified-Since": modified})
+	assert.False(t, done)
+
+	// Test: If-Modified-Since
+	done, out = checkPreconditions(t, "GET", map[string]string{"If-Modified-Since": modified})
+	assert.True(t, done)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+	done, _ = checkPreconditions(t, "GET", map[string]string{"If-Modified-Since": before})
+	assert.False(t, done)
+	done, _ = checkPreconditions(t, "GET", map[string]string{"If-Modified-Since": "yesterday"})
+	assert.False(t, done)
+
+	// Test: If-Modified-Since only applies to GET and HEAD
+	done, _ = checkPreconditions(t, "PUT", map[string]string{"If-Modified-Since": modified})
+	assert.False(t, done)
+
+	// Test: If-Match uses strong comparison
+	done, _ = checkPreconditions(t, "PUT", map[string]string{"If-Match": `"v2"`})
+	assert.False(t, done)
+	done, out = checkPreconditions(t, "PUT", map[string]string{"If-Match": `W/"v2"`})
+	assert.True(t, done)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 412 Precondition Failed\r\n"))
+	done, _ = checkPreconditions(t, "PUT", map[string]string{"If-Match": "*"})
+	assert.False(t, done)
+
+	// Test: If-Match takes precedence over If-Unmodified-Since
+	done, _ = checkPreconditions(t, "PUT", map[string]string{"If-Match": `"v2"`, "If-Unmodified-Since": before})
+	assert.False(t, done)
+
+	// Test: If-Unmodified-Since
+	done, out = checkPreconditions(t, "PUT", map[string]string{"If-Unmodified-Since": before})
+	assert.True(t, done)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 412 Precondition Failed\r\n"))
+	done, _ = checkPreconditions(t, "PUT", map[string]string{"If-Unmodified-Since": modified})
+	assert.False(t, done)
+}
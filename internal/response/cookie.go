@@ -0,0 +1,169 @@
+package response
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+)
+
+// TimeFormat is the IMF-fixdate layout used for HTTP dates
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+// Cookie describes a single Set-Cookie line. A zero Expires and a zero
+// MaxAge leave those attributes out; a negative MaxAge deletes the cookie.
+type Cookie struct {
+	Name        string
+	Value       string
+	Path        string
+	Domain      string
+	Expires     time.Time
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// Serialize validates the cookie and renders it as a Set-Cookie field
+// value
+func (c *Cookie) Serialize() (string, error) {
+	if err := c.validate(); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(c.Value)
+
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	switch c.SameSite {
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		b.WriteString("; SameSite=None")
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String(), nil
+}
+
+func (c *Cookie) validate() error {
+	if !headers.IsToken(c.Name) {
+		return fmt.Errorf("invalid cookie name: %q", c.Name)
+	}
+	for i := 0; i < len(c.Value); i++ {
+		if !headers.IsCookieOctet(c.Value[i]) {
+			return fmt.Errorf("invalid byte %q in cookie value", c.Value[i])
+		}
+	}
+	if !validAttributeValue(c.Path) {
+		return fmt.Errorf("invalid cookie path: %q", c.Path)
+	}
+	if !validCookieDomain(c.Domain) {
+		return fmt.Errorf("invalid cookie domain: %q", c.Domain)
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return fmt.Errorf("invalid cookie expiry: %v", c.Expires)
+	}
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return fmt.Errorf("SameSite=None cookies must be Secure")
+	}
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("partitioned cookies must be Secure")
+	}
+	return nil
+}
+
+func validAttributeValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if v[i] < 0x20 || v[i] == 0x7F || v[i] == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+func validCookieDomain(d string) bool {
+	d = strings.TrimPrefix(d, ".")
+	if d == "" {
+		return true
+	}
+	if len(d) > 255 {
+		return false
+	}
+	for _, label := range strings.Split(d, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			isAlnum := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+			if !isAlnum && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SetCookie queues a Set-Cookie line to be sent with the headers. It must
+// be called before WriteHeaders.
+func (w *Writer) SetCookie(c *Cookie) error {
+	if w.headersWritten {
+		return fmt.Errorf("cannot set cookie after headers are written")
+	}
+	line, err := c.Serialize()
+	if err != nil {
+		return err
+	}
+	return w.SetCookieLine(line)
+}
+
+// SetCookieLine queues a Set-Cookie line with an already serialized
+// value, such as one relayed from another server. It must be called
+// before WriteHeaders.
+func (w *Writer) SetCookieLine(value string) error {
+	if w.headersWritten {
+		return fmt.Errorf("cannot set cookie after headers are written")
+	}
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Errorf("invalid Set-Cookie value")
+	}
+	w.cookies = append(w.cookies, value)
+	return nil
+}
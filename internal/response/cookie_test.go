@@ -0,0 +1,78 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieString(t *testing.T) {
+	// Test: All attributes
+	c := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     time.Date(2030, time.January, 2, 15, 4, 5, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	s, err := c.Serialize()
+	require.NoError(t, err)
+	assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Wed, 02 Jan 2030 15:04:05 GMT; Max-Age=3600; HttpOnly; Secure; SameSite=None; Partitioned", s)
+
+	// Test: Negative MaxAge deletes the cookie
+	s, err = (&Cookie{Name: "gone", MaxAge: -1}).Serialize()
+	require.NoError(t, err)
+	assert.Equal(t, "gone=; Max-Age=0", s)
+
+	// Test: Invalid name
+	_, err = (&Cookie{Name: "bad name", Value: "x"}).Serialize()
+	require.Error(t, err)
+
+	// Test: Invalid value
+	_, err = (&Cookie{Name: "n", Value: "a;b"}).Serialize()
+	require.Error(t, err)
+
+	// Test: Invalid path
+	_, err = (&Cookie{Name: "n", Path: "/a;b"}).Serialize()
+	require.Error(t, err)
+
+	// Test: Invalid domain
+	_, err = (&Cookie{Name: "n", Domain: "-bad-.com"}).Serialize()
+	require.Error(t, err)
+
+	// Test: SameSite=None without Secure
+	_, err = (&Cookie{Name: "n", SameSite: SameSiteNone}).Serialize()
+	require.Error(t, err)
+
+	// Test: Partitioned without Secure
+	_, err = (&Cookie{Name: "n", Partitioned: true}).Serialize()
+	require.Error(t, err)
+}
+
+func TestWriterSetCookie(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.SetCookie(&Cookie{Name: "a", Value: "1"}))
+	require.NoError(t, w.SetCookie(&Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	require.Error(t, w.SetCookie(&Cookie{Name: "bad name"}))
+	require.NoError(t, w.SetCookieLine("c=3; Path=/"))
+	require.Error(t, w.SetCookieLine("d=4\r\nX-Injected: yes"))
+
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	out := buf.String()
+	assert.True(t, strings.HasSuffix(out, "Set-Cookie: a=1\r\nSet-Cookie: b=2; HttpOnly\r\nSet-Cookie: c=3; Path=/\r\n\r\n"))
+
+	// Test: Cookies cannot be set once headers are out
+	require.Error(t, w.SetCookie(&Cookie{Name: "late", Value: "1"}))
+}
@@ -0,0 +1,447 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+)
+
+// Response is a response read from a server
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	Body       []byte
+	// Trailers holds the fields sent after a chunked body, if any
+	Trailers headers.Headers
+
+	method         string
+	state          responseState
+	bodyLength     int
+	bodyLengthRead int
+	chunkRemaining int
+	// fields lists the header field lines in the order they were received
+	fields []field
+
+	// set by ResponseHeadFromReader, for BodyReader
+	br         *bufio.Reader
+	fieldBytes int
+}
+
+// field is one header field line as it was received
+type field struct {
+	name  string
+	value string
+}
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+type responseState int
+
+const (
+	responseStateInitialized responseState = iota
+	responseStateParsingHeaders
+	responseStateParsingBody
+	responseStateParsingBodyUntilEOF
+	responseStateParsingChunkSize
+	responseStateParsingChunkData
+	responseStateParsingChunkEnd
+	responseStateParsingTrailers
+	responseStateDone
+)
+
+const crlf = "\r\n"
+const bufferSize = 8
+
+// maxFieldBytes bounds the status lines, headers and trailers of a
+// response read by ResponseHeadFromReader
+const maxFieldBytes = 1 << 20
+
+// ResponseFromReader reads one complete response. method is that of the
+// request being answered, since responses to HEAD carry no body whatever
+// their headers say. Interim 1xx responses other than 101 are skipped.
+func ResponseFromReader(reader io.Reader, method string) (*Response, error) {
+	buf := make([]byte, bufferSize, bufferSize)
+	readToIndex := 0
+	resp := &Response{
+		method:   method,
+		state:    responseStateInitialized,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		Body:     make([]byte, 0),
+	}
+	for resp.state != responseStateDone {
+		if readToIndex >= len(buf) {
+			newBuf := make([]byte, len(buf)*2)
+			copy(newBuf, buf)
+			buf = newBuf
+		}
+
+		numBytesRead, err := reader.Read(buf[readToIndex:])
+		readToIndex += numBytesRead
+		if numBytesRead > 0 {
+			numBytesParsed, err := resp.parse(buf[:readToIndex])
+			if err != nil {
+				return nil, err
+			}
+			copy(buf, buf[numBytesParsed:])
+			readToIndex -= numBytesParsed
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				// a body without a declared length ends with the connection
+				if resp.state == responseStateParsingBodyUntilEOF {
+					resp.state = responseStateDone
+					break
+				}
+				if resp.state != responseStateDone {
+					return nil, fmt.Errorf("incomplete response, in state: %d, read n bytes on EOF: %d", resp.state, numBytesRead)
+				}
+				break
+			}
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// ResponseHeadFromReader reads a response up to the end of its headers,
+// skipping interim responses as ResponseFromReader does, and leaves the
+// body in br to be streamed through BodyReader. Nothing past the headers
+// is consumed, so no line may be longer than br's buffer.
+func ResponseHeadFromReader(br *bufio.Reader, method string) (*Response, error) {
+	resp := &Response{
+		method:   method,
+		state:    responseStateInitialized,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		Body:     make([]byte, 0),
+		br:       br,
+	}
+	for resp.state == responseStateInitialized || resp.state == responseStateParsingHeaders {
+		if err := resp.step(); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// BodyReader streams the body of a response read by
+// ResponseHeadFromReader, with any chunked framing removed. Trailers are
+// filled in once it returns io.EOF.
+func (r *Response) BodyReader() io.Reader {
+	return &bodyReader{r}
+}
+
+type bodyReader struct {
+	resp *Response
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	r := b.resp
+	if r.br == nil {
+		return 0, fmt.Errorf("response was not read by ResponseHeadFromReader")
+	}
+	for len(r.Body) == 0 {
+		if r.state == responseStateDone {
+			return 0, io.EOF
+		}
+		if err := r.step(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.Body)
+	r.Body = r.Body[n:]
+	return n, nil
+}
+
+// Values returns the value of each line of the named header field, in
+// the order received. Headers joins repeated lines with ", ", which
+// fields such as Set-Cookie cannot survive.
+func (r *Response) Values(name string) []string {
+	values := make([]string, 0)
+	for _, f := range r.fields {
+		if strings.EqualFold(f.name, name) {
+			values = append(values, f.value)
+		}
+	}
+	return values
+}
+
+// ContentLength returns the length of the body: 0 for responses that
+// carry none, or -1 when it is chunked or runs until the connection closes
+func (r *Response) ContentLength() int64 {
+	if r.hasNoBody() {
+		return 0
+	}
+	return int64(r.bodyLength)
+}
+
+// step feeds what br has buffered to the parser for one state
+// transition, reading from the connection only when that is not enough
+func (r *Response) step() error {
+	want := max(r.br.Buffered(), 1)
+	for {
+		data, err := r.br.Peek(want)
+		if len(data) > 0 {
+			state := r.state
+			n, parseErr := r.parseSingle(data)
+			if parseErr != nil {
+				return parseErr
+			}
+			if state <= responseStateParsingHeaders || state == responseStateParsingTrailers {
+				r.fieldBytes += n
+				if r.fieldBytes > maxFieldBytes {
+					return fmt.Errorf("response header fields too large")
+				}
+			}
+			r.br.Discard(n)
+			if n > 0 || r.state != state {
+				return nil
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && r.state == responseStateParsingBodyUntilEOF {
+				r.state = responseStateDone
+				return nil
+			}
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("incomplete response, in state: %d", r.state)
+			}
+			if errors.Is(err, bufio.ErrBufferFull) {
+				return fmt.Errorf("response line longer than %d bytes", r.br.Size())
+			}
+			return err
+		}
+		want = len(data) + 1
+	}
+}
+
+// KeepAlive reports whether the connection the response arrived on may
+// carry another request
+func (r *Response) KeepAlive() bool {
+	if r.StatusLine.StatusCode == 101 {
+		return false
+	}
+	keepAlive := r.StatusLine.HttpVersion == "1.1"
+	if connection, ok := r.Headers.Get("Connection"); ok {
+		for _, option := range strings.Split(connection, ",") {
+			switch strings.ToLower(strings.TrimSpace(option)) {
+			case "close":
+				return false
+			case "keep-alive":
+				keepAlive = true
+			}
+		}
+	}
+	return keepAlive && !r.closeDelimited()
+}
+
+func (r *Response) closeDelimited() bool {
+	if r.hasNoBody() {
+		return false
+	}
+	if _, ok := r.Headers.Get("Content-Length"); ok {
+		return false
+	}
+	te, _ := r.Headers.Get("Transfer-Encoding")
+	return !strings.EqualFold(strings.TrimSpace(te), "chunked")
+}
+
+// hasNoBody reports whether the response is one that never carries a
+// body, see RFC 9112 6.3
+func (r *Response) hasNoBody() bool {
+	code := r.StatusLine.StatusCode
+	return r.method == "HEAD" || code < 200 || code == NoContent || code == NotModified
+}
+
+func parseStatusLine(data []byte) (*StatusLine, int, error) {
+	idx := bytes.Index(data, []byte(crlf))
+	if idx == -1 {
+		return nil, 0, nil
+	}
+	statusLine, err := statusLineFromString(string(data[:idx]))
+	if err != nil {
+		return nil, 0, err
+	}
+	return statusLine, idx + 2, nil
+}
+
+func statusLineFromString(str string) (*StatusLine, error) {
+	version, rest, ok := strings.Cut(str, " ")
+	if !ok {
+		return nil, fmt.Errorf("poorly formatted status-line: %s", str)
+	}
+	versionParts := strings.Split(version, "/")
+	if len(versionParts) != 2 || versionParts[0] != "HTTP" {
+		return nil, fmt.Errorf("unrecognized HTTP-version: %s", version)
+	}
+	if versionParts[1] != "1.1" && versionParts[1] != "1.0" {
+		return nil, fmt.Errorf("unrecognized HTTP-version: %s", versionParts[1])
+	}
+
+	code, reason, _ := strings.Cut(rest, " ")
+	statusCode, err := strconv.Atoi(code)
+	if err != nil || len(code) != 3 || statusCode < 100 {
+		return nil, fmt.Errorf("invalid status code: %s", code)
+	}
+
+	return &StatusLine{
+		HttpVersion:  versionParts[1],
+		StatusCode:   StatusCode(statusCode),
+		ReasonPhrase: reason,
+	}, nil
+}
+
+func (r *Response) parse(data []byte) (int, error) {
+	totalBytesParsed := 0
+	for r.state != responseStateDone {
+		n, err := r.parseSingle(data[totalBytesParsed:])
+		if err != nil {
+			return 0, err
+		}
+		totalBytesParsed += n
+		if n == 0 {
+			break
+		}
+	}
+	return totalBytesParsed, nil
+}
+
+func (r *Response) parseSingle(data []byte) (int, error) {
+	switch r.state {
+	case responseStateInitialized:
+		statusLine, n, err := parseStatusLine(data)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, nil
+		}
+		r.StatusLine = *statusLine
+		r.state = responseStateParsingHeaders
+		return n, nil
+	case responseStateParsingHeaders:
+		n, done, err := r.Headers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			return n, r.startBody()
+		}
+		if n > 0 {
+			name, value, _ := strings.Cut(string(data[:n]), ":")
+			r.fields = append(r.fields, field{strings.TrimSpace(name), strings.TrimSpace(value)})
+		}
+		return n, nil
+	case responseStateParsingBody:
+		n := min(len(data), r.bodyLength-r.bodyLengthRead)
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyLengthRead += n
+		if r.bodyLengthRead == r.bodyLength {
+			r.state = responseStateDone
+		}
+		return n, nil
+	case responseStateParsingBodyUntilEOF:
+		r.Body = append(r.Body, data...)
+		return len(data), nil
+	case responseStateParsingChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		// chunk extensions after ';' carry nothing we use
+		sizeField, _, _ := strings.Cut(string(data[:idx]), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 32)
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("malformed chunk size: %q", data[:idx])
+		}
+		if size == 0 {
+			r.state = responseStateParsingTrailers
+		} else {
+			r.chunkRemaining = int(size)
+			r.state = responseStateParsingChunkData
+		}
+		return idx + 2, nil
+	case responseStateParsingChunkData:
+		n := min(len(data), r.chunkRemaining)
+		r.Body = append(r.Body, data[:n]...)
+		r.chunkRemaining -= n
+		if r.chunkRemaining == 0 {
+			r.state = responseStateParsingChunkEnd
+		}
+		return n, nil
+	case responseStateParsingChunkEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if string(data[:2]) != crlf {
+			return 0, fmt.Errorf("malformed chunk terminator")
+		}
+		r.state = responseStateParsingChunkSize
+		return 2, nil
+	case responseStateParsingTrailers:
+		n, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.state = responseStateDone
+		}
+		return n, nil
+	case responseStateDone:
+		return 0, fmt.Errorf("error: trying to read data in a done state")
+	default:
+		return 0, fmt.Errorf("unknown state")
+	}
+}
+
+// startBody picks how the body is framed once the headers are in
+func (r *Response) startBody() error {
+	code := r.StatusLine.StatusCode
+	if code >= 100 && code < 200 && code != 101 {
+		// an interim response, the real one follows
+		r.Headers = headers.NewHeaders()
+		r.fields = nil
+		r.state = responseStateInitialized
+		return nil
+	}
+	if r.hasNoBody() {
+		r.state = responseStateDone
+		return nil
+	}
+
+	if te, ok := r.Headers.Get("Transfer-Encoding"); ok {
+		if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+			return fmt.Errorf("unsupported Transfer-Encoding: %s", te)
+		}
+		r.bodyLength = -1
+		r.state = responseStateParsingChunkSize
+		return nil
+	}
+	contentLenStr, ok := r.Headers.Get("Content-Length")
+	if !ok {
+		r.bodyLength = -1
+		r.state = responseStateParsingBodyUntilEOF
+		return nil
+	}
+	contentLen, err := strconv.Atoi(contentLenStr)
+	if err != nil || contentLen < 0 {
+		return fmt.Errorf("malformed Content-Length: %s", contentLenStr)
+	}
+	r.bodyLength = contentLen
+	r.state = responseStateParsingBody
+	if contentLen == 0 {
+		r.state = responseStateDone
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package response
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.numBytesPerRead
+	if endIndex > len(cr.data) {
+		endIndex = len(cr.data)
+	}
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
+
+func TestStatusLineParse(t *testing.T) {
+	// Test: Good status line
+	reader := &chunkReader{
+		data:            "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "1.1", r.StatusLine.HttpVersion)
+	assert.Equal(t, NotFound, r.StatusLine.StatusCode)
+	assert.Equal(t, "Not Found", r.StatusLine.ReasonPhrase)
+
+	// Test: Empty reason phrase and unknown code
+	reader = &chunkReader{
+		data:            "HTTP/1.0 299 \r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(299), r.StatusLine.StatusCode)
+	assert.Equal(t, "", r.StatusLine.ReasonPhrase)
+
+	// Test: Invalid version
+	reader = &chunkReader{
+		data:            "HTTP/2 200 OK\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+
+	// Test: Invalid status code
+	reader = &chunkReader{
+		data:            "HTTP/1.1 20 OK\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+}
+
+func TestResponseBodyParse(t *testing.T) {
+	// Test: Content-Length body
+	reader := &chunkReader{
+		data: "HTTP/1.1 200 OK\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.True(t, r.KeepAlive())
+
+	// Test: Chunked body with extensions and trailers
+	reader = &chunkReader{
+		data: "HTTP/1.1 200 OK\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5;name=value\r\nhello\r\n" +
+			"7\r\n world!\r\n" +
+			"0\r\n" +
+			"X-Content-Length: 12\r\n" +
+			"\r\n",
+		numBytesPerRead: 2,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(r.Body))
+	length, ok := r.Trailers.Get("X-Content-Length")
+	require.True(t, ok)
+	assert.Equal(t, "12", length)
+	assert.True(t, r.KeepAlive())
+
+	// Test: Body delimited by the end of the connection
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil the end",
+		numBytesPerRead: 4,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(r.Body))
+	assert.False(t, r.KeepAlive())
+
+	// Test: Body shorter than reported content length
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 20\r\n\r\npartial content",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+
+	// Test: Malformed chunk size
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+
+	// Test: Connection: close
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+}
+
+func TestResponseWithoutBodyParse(t *testing.T) {
+	// Test: Interim responses are skipped
+	reader := &chunkReader{
+		data: "HTTP/1.1 100 Continue\r\n\r\n" +
+			"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok",
+		numBytesPerRead: 5,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, OK, r.StatusLine.StatusCode)
+	assert.Equal(t, "ok", string(r.Body))
+	_, ok := r.Headers.Get("Link")
+	assert.False(t, ok)
+
+	// Test: 204 and 304 have no body
+	for _, status := range []string{"204 No Content", "304 Not Modified"} {
+		reader = &chunkReader{
+			data:            "HTTP/1.1 " + status + "\r\nContent-Length: 10\r\n\r\n",
+			numBytesPerRead: 3,
+		}
+		r, err = ResponseFromReader(reader, "GET")
+		require.NoError(t, err)
+		assert.Empty(t, r.Body)
+		assert.True(t, r.KeepAlive())
+	}
+
+	// Test: Responses to HEAD have no body
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = ResponseFromReader(reader, "HEAD")
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+	length, _ := r.Headers.Get("Content-Length")
+	assert.Equal(t, "1000", length)
+
+	// Test: 101 ends the response
+	reader = &chunkReader{
+		data:            "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(101), r.StatusLine.StatusCode)
+	assert.False(t, r.KeepAlive())
+}
+
+func TestResponseStreaming(t *testing.T) {
+	// Test: The head is read without consuming the body
+	br := bufio.NewReader(&chunkReader{
+		data:            "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhelloextra",
+		numBytesPerRead: 4,
+	})
+	r, err := ResponseHeadFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, OK, r.StatusLine.StatusCode)
+	assert.Equal(t, int64(5), r.ContentLength())
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	rest, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Equal(t, "extra", string(rest))
+
+	// Test: Chunked bodies are decoded and their trailers kept
+	br = bufio.NewReader(&chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\nX-Sum: abc\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	r, err = ResponseHeadFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), r.ContentLength())
+	body, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	sum, _ := r.Trailers.Get("X-Sum")
+	assert.Equal(t, "abc", sum)
+
+	// Test: A body without a length runs until EOF
+	br = bufio.NewReader(&chunkReader{data: "HTTP/1.0 200 OK\r\n\r\nuntil the end", numBytesPerRead: 5})
+	r, err = ResponseHeadFromReader(br, "GET")
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(body))
+
+	// Test: Responses to HEAD have no body to stream
+	br = bufio.NewReader(&chunkReader{data: "HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n", numBytesPerRead: 5})
+	r, err = ResponseHeadFromReader(br, "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), r.ContentLength())
+
+	// Test: Truncated bodies and oversized lines fail
+	br = bufio.NewReader(&chunkReader{data: "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort", numBytesPerRead: 5})
+	r, err = ResponseHeadFromReader(br, "GET")
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader())
+	assert.Error(t, err)
+	br = bufio.NewReaderSize(&chunkReader{data: "HTTP/1.1 200 OK\r\nX-Long: " + strings.Repeat("a", 100) + "\r\n\r\n", numBytesPerRead: 7}, 16)
+	_, err = ResponseHeadFromReader(br, "GET")
+	assert.Error(t, err)
+}
@@ -0,0 +1,270 @@
+package response
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+)
+
+// ByteRange is a span of Length bytes starting at Start
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+func (r ByteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+var (
+	// ErrMalformedRange means the Range header should be ignored
+	ErrMalformedRange = errors.New("malformed range")
+	// ErrUnsatisfiableRange means no requested range overlaps the content
+	ErrUnsatisfiableRange = errors.New("range not satisfiable")
+	// ErrExcessiveRanges means the ranges ask for more bytes than the
+	// content has, which only overlapping ranges can do; the Range header
+	// should be ignored, see RFC 9110 14.2
+	ErrExcessiveRanges = errors.New("ranges overlap too much")
+)
+
+// maxRanges bounds how many ranges one request may ask for, which bounds
+// the part headers a multipart response spends on them
+const maxRanges = 100
+
+// ParseRange parses a Range header value such as "bytes=0-99,200-,-50"
+// against content of the given size. Ranges that start past the end are
+// dropped; if none remain ErrUnsatisfiableRange is returned. Overlapping
+// and adjacent ranges are merged, and the result is in ascending order.
+// Ranges totalling more than size yield ErrExcessiveRanges, so that a
+// client cannot have the same bytes sent over and over.
+func ParseRange(header string, size int64) ([]ByteRange, error) {
+	unit, spec, ok := strings.Cut(header, "=")
+	if !ok || strings.TrimSpace(unit) != "bytes" {
+		return nil, ErrMalformedRange
+	}
+
+	ranges := make([]ByteRange, 0)
+	parts := strings.Split(spec, ",")
+	if len(parts) > maxRanges {
+		return nil, ErrMalformedRange
+	}
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, ErrMalformedRange
+		}
+
+		if first == "" {
+			// suffix range: the final N bytes
+			n, err := parseRangeInt(last)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			ranges = append(ranges, ByteRange{Start: size - n, Length: n})
+			continue
+		}
+
+		start, err := parseRangeInt(first)
+		if err != nil {
+			return nil, err
+		}
+		end := size - 1
+		if last != "" {
+			end, err = parseRangeInt(last)
+			if err != nil {
+				return nil, err
+			}
+			if end < start {
+				return nil, ErrMalformedRange
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, ByteRange{Start: start, Length: end - start + 1})
+	}
+
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiableRange
+	}
+	total := int64(0)
+	for _, r := range ranges {
+		total += r.Length
+	}
+	if total > size {
+		return nil, ErrExcessiveRanges
+	}
+	return coalesceRanges(ranges), nil
+}
+
+// coalesceRanges sorts ranges and merges those that overlap or touch
+func coalesceRanges(ranges []ByteRange) []ByteRange {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start > last.Start+last.Length {
+			merged = append(merged, r)
+			continue
+		}
+		last.Length = max(last.Length, r.Start+r.Length-last.Start)
+	}
+	return merged
+}
+
+func parseRangeInt(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, ErrMalformedRange
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrMalformedRange
+	}
+	return n, nil
+}
+
+// ServeContent writes content as the response body, honouring Range and
+// If-Range on GET requests with 206 Partial Content, multipart/byteranges
+// for several ranges, or 416 Range Not Satisfiable. h holds the entity
+// headers, such as Content-Type, ETag and Last-Modified, which are sent
+// with the response and used to evaluate the request's preconditions.
+func ServeContent(w *Writer, req *request.Request, h headers.Headers, content io.ReadSeeker) {
+	if _, ok := h.Get("Connection"); !ok {
+		h.Set("Connection", "close")
+	}
+	if CheckPreconditions(w, req, h) {
+		return
+	}
+
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		w.WriteError(InternalError, nil)
+		return
+	}
+
+	h.Set("Accept-Ranges", "bytes")
+
+	var ranges []ByteRange
+	rangeHeader, ok := req.Headers.Get("Range")
+	if ok && req.RequestLine.Method == "GET" && ifRangeMatches(req, h) {
+		ranges, err = ParseRange(rangeHeader, size)
+		if errors.Is(err, ErrUnsatisfiableRange) {
+			errHeaders := headers.NewHeaders()
+			errHeaders.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			errHeaders.Set("Accept-Ranges", "bytes")
+			w.WriteError(RangeNotSatisfiable, errHeaders)
+			return
+		}
+		if err != nil {
+			// a malformed or excessive Range header is ignored rather
+			// than rejected
+			ranges = nil
+		}
+	}
+
+	switch len(ranges) {
+	case 0:
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteStatusLine(OK)
+		w.WriteHeaders(h)
+		io.Copy(w, content)
+	case 1:
+		r := ranges[0]
+		h.Set("Content-Range", r.contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(r.Length, 10))
+		if _, err := content.Seek(r.Start, io.SeekStart); err != nil {
+			w.WriteError(InternalError, nil)
+			return
+		}
+		w.WriteStatusLine(PartialContent)
+		w.WriteHeaders(h)
+		io.CopyN(w, content, r.Length)
+	default:
+		serveMultipartRanges(w, h, content, ranges, size)
+	}
+}
+
+func serveMultipartRanges(w *Writer, h headers.Headers, content io.ReadSeeker, ranges []ByteRange, size int64) {
+	boundaryBytes := make([]byte, 16)
+	rand.Read(boundaryBytes)
+	boundary := hex.EncodeToString(boundaryBytes)
+
+	partType, _ := h.Get("Content-Type")
+	partHeaders := make([]string, len(ranges))
+	length := int64(0)
+	for i, r := range ranges {
+		head := "--" + boundary + "\r\n"
+		if partType != "" {
+			head += "Content-Type: " + partType + "\r\n"
+		}
+		head += "Content-Range: " + r.contentRange(size) + "\r\n\r\n"
+		partHeaders[i] = head
+		length += int64(len(head)) + r.Length + int64(len("\r\n"))
+	}
+	closing := "--" + boundary + "--\r\n"
+	length += int64(len(closing))
+
+	h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteStatusLine(PartialContent)
+	w.WriteHeaders(h)
+	for i, r := range ranges {
+		if _, err := content.Seek(r.Start, io.SeekStart); err != nil {
+			return
+		}
+		w.WriteBody([]byte(partHeaders[i]))
+		if _, err := io.CopyN(w, content, r.Length); err != nil {
+			return
+		}
+		w.WriteBody([]byte("\r\n"))
+	}
+	w.WriteBody([]byte(closing))
+}
+
+// ifRangeMatches reports whether the ranges may be served: either there is
+// no If-Range, or it names the current representation by strong ETag or
+// by its exact Last-Modified date
+func ifRangeMatches(req *request.Request, h headers.Headers) bool {
+	ifRange, ok := req.Headers.Get("If-Range")
+	if !ok {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		value, _ := h.Get("ETag")
+		current, ok := parseETag(value)
+		return ok && !current.weak && matchETags(ifRange, current, true)
+	}
+	lastModified, ok := h.Get("Last-Modified")
+	if !ok {
+		return false
+	}
+	want, err := time.Parse(TimeFormat, ifRange)
+	if err != nil {
+		return false
+	}
+	have, err := time.Parse(TimeFormat, lastModified)
+	return err == nil && want.Equal(have)
+}
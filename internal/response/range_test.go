@@ -0,0 +1,158 @@
+package response
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	// Test: Single range
+	ranges, err := ParseRange("bytes=0-9", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 10}}, ranges)
+
+	// Test: Open-ended and suffix ranges
+	ranges, err = ParseRange("bytes=80-89, -5", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 80, Length: 10}, {Start: 95, Length: 5}}, ranges)
+
+	// Test: End past the content is clamped
+	ranges, err = ParseRange("bytes=50-500", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 50, Length: 50}}, ranges)
+
+	// Test: Suffix longer than the content covers all of it
+	ranges, err = ParseRange("bytes=-500", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 100}}, ranges)
+
+	// Test: Unsatisfiable ranges are dropped
+	ranges, err = ParseRange("bytes=0-0,200-300", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 1}}, ranges)
+
+	// Test: Overlapping and adjacent ranges are merged in order
+	ranges, err = ParseRange("bytes=50-59,0-9,5-14,15-19,55-", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 20}, {Start: 50, Length: 50}}, ranges)
+
+	// Test: Ranges asking for more than the content are excessive
+	_, err = ParseRange("bytes=0-,0-", 100)
+	assert.ErrorIs(t, err, ErrExcessiveRanges)
+	_, err = ParseRange("bytes=0-59,40-99", 100)
+	assert.ErrorIs(t, err, ErrExcessiveRanges)
+
+	// Test: Nothing satisfiable
+	_, err = ParseRange("bytes=100-", 100)
+	assert.ErrorIs(t, err, ErrUnsatisfiableRange)
+
+	// Test: Malformed ranges
+	for _, header := range []string{"items=0-1", "bytes=5-1", "bytes=a-b", "bytes=1", "bytes=-", "bytes=+1-2"} {
+		_, err = ParseRange(header, 100)
+		assert.ErrorIs(t, err, ErrMalformedRange, header)
+	}
+}
+
+func serveContent(t *testing.T, method, body string, reqHeaders map[string]string, h headers.Headers) string {
+	t.Helper()
+	raw := method + " /video HTTP/1.1\r\nHost: localhost\r\n"
+	for k, v := range reqHeaders {
+		raw += k + ": " + v + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	if h == nil {
+		h = headers.NewHeaders()
+		h.Set("Content-Type", "text/plain")
+	}
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	ServeContent(&w, req, h, strings.NewReader(body))
+	return out.String()
+}
+
+func TestServeContent(t *testing.T) {
+	body := "0123456789abcdefghij"
+
+	// Test: No Range serves everything and advertises ranges
+	out := serveContent(t, "GET", body, nil, nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "accept-ranges: bytes\r\n")
+	assert.Contains(t, out, "content-length: 20\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+body))
+
+	// Test: Single range
+	out = serveContent(t, "GET", body, map[string]string{"Range": "bytes=5-9"}, nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, out, "content-range: bytes 5-9/20\r\n")
+	assert.Contains(t, out, "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n56789"))
+
+	// Test: Multiple ranges are sent as multipart/byteranges
+	out = serveContent(t, "GET", body, map[string]string{"Range": "bytes=0-1,-2"}, nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	head, payload, ok := strings.Cut(out, "\r\n\r\n")
+	require.True(t, ok)
+	_, boundary, ok := strings.Cut(head, "content-type: multipart/byteranges; boundary=")
+	require.True(t, ok)
+	boundary, _, _ = strings.Cut(boundary, "\r\n")
+	assert.Equal(t, "--"+boundary+"\r\nContent-Type: text/plain\r\nContent-Range: bytes 0-1/20\r\n\r\n01\r\n"+
+		"--"+boundary+"\r\nContent-Type: text/plain\r\nContent-Range: bytes 18-19/20\r\n\r\nij\r\n"+
+		"--"+boundary+"--\r\n", payload)
+	assert.Contains(t, head, "content-length: "+strconv.Itoa(len(payload)))
+
+	// Test: Overlapping ranges are sent once
+	out = serveContent(t, "GET", body, map[string]string{"Range": "bytes=0-4,2-7,8-9"}, nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, out, "content-range: bytes 0-9/20\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n0123456789"))
+
+	// Test: Repeating the whole content gets a plain 200
+	out = serveContent(t, "GET", body, map[string]string{"Range": "bytes=" + strings.Repeat("0-,", 99) + "0-"}, nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-length: 20\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+body))
+
+	// Test: Unsatisfiable range
+	out = serveContent(t, "GET", body, map[string]string{"Range": "bytes=50-"}, nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+	assert.Contains(t, out, "content-range: bytes */20\r\n")
+
+	// Test: Malformed range is ignored
+	out = serveContent(t, "GET", body, map[string]string{"Range": "bytes=9-1"}, nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Range only applies to GET
+	out = serveContent(t, "POST", body, map[string]string{"Range": "bytes=0-1"}, nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: If-Range with a matching strong ETag
+	h := headers.NewHeaders()
+	h.Set("ETag", `"v1"`)
+	out = serveContent(t, "GET", body, map[string]string{"Range": "bytes=0-1", "If-Range": `"v1"`}, h)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+
+	// Test: If-Range with a stale ETag serves everything
+	h = headers.NewHeaders()
+	h.Set("ETag", `"v2"`)
+	out = serveContent(t, "GET", body, map[string]string{"Range": "bytes=0-1", "If-Range": `"v1"`}, h)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: If-Range with a matching date
+	h = headers.NewHeaders()
+	h.Set("Last-Modified", "Wed, 02 Jan 2030 15:04:05 GMT")
+	out = serveContent(t, "GET", body, map[string]string{"Range": "bytes=0-1", "If-Range": "Wed, 02 Jan 2030 15:04:05 GMT"}, h)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+
+	// Test: If-Range with an older date serves everything
+	out = serveContent(t, "GET", body, map[string]string{"Range": "bytes=0-1", "If-Range": "Tue, 01 Jan 2030 15:04:05 GMT"}, h)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+}
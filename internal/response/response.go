@@ -2,24 +2,137 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
+	"strings"
 
 	"github.com/colfarl/httpfromtcp/internal/headers"
 )
 
 type StatusCode int
 const (
+	Continue			StatusCode = 100
+	SwitchingProtocols	StatusCode = 101
+	EarlyHints			StatusCode = 103
+
 	OK				StatusCode = 200
-	BadRequest		StatusCode = 400
-	InternalError	StatusCode = 500
+	Created			StatusCode = 201
+	Accepted		StatusCode = 202
+	NoContent		StatusCode = 204
+	PartialContent	StatusCode = 206
+
+	MovedPermanently	StatusCode = 301
+	Found				StatusCode = 302
+	SeeOther			StatusCode = 303
+	NotModified			StatusCode = 304
+	TemporaryRedirect	StatusCode = 307
+	PermanentRedirect	StatusCode = 308
+
+	BadRequest				StatusCode = 400
+	Unauthorized			StatusCode = 401
+	Forbidden				StatusCode = 403
+	NotFound				StatusCode = 404
+	MethodNotAllowed		StatusCode = 405
+	RequestTimeout			StatusCode = 408
+	Conflict				StatusCode = 409
+	Gone					StatusCode = 410
+	LengthRequired			StatusCode = 411
+	PreconditionFailed		StatusCode = 412
+	ContentTooLarge			StatusCode = 413
+	URITooLong				StatusCode = 414
+	UnsupportedMediaType	StatusCode = 415
+	RangeNotSatisfiable		StatusCode = 416
+	ExpectationFailed		StatusCode = 417
+	TooManyRequests			StatusCode = 429
+
+	InternalError		StatusCode = 500
+	NotImplemented		StatusCode = 501
+	BadGateway			StatusCode = 502
+	ServiceUnavailable	StatusCode = 503
+	GatewayTimeout		StatusCode = 504
 )
 
+var statusText = map[StatusCode]string{
+	Continue:           "Continue",
+	SwitchingProtocols: "Switching Protocols",
+	EarlyHints:         "Early Hints",
+
+	OK:             "OK",
+	Created:        "Created",
+	Accepted:       "Accepted",
+	NoContent:      "No Content",
+	PartialContent: "Partial Content",
+
+	MovedPermanently:  "Moved Permanently",
+	Found:             "Found",
+	SeeOther:          "See Other",
+	NotModified:       "Not Modified",
+	TemporaryRedirect: "Temporary Redirect",
+	PermanentRedirect: "Permanent Redirect",
+
+	BadRequest:           "Bad Request",
+	Unauthorized:         "Unauthorized",
+	Forbidden:            "Forbidden",
+	NotFound:             "Not Found",
+	MethodNotAllowed:     "Method Not Allowed",
+	RequestTimeout:       "Request Timeout",
+	Conflict:             "Conflict",
+	Gone:                 "Gone",
+	LengthRequired:       "Length Required",
+	PreconditionFailed:   "Precondition Failed",
+	ContentTooLarge:      "Content Too Large",
+	URITooLong:           "URI Too Long",
+	UnsupportedMediaType: "Unsupported Media Type",
+	RangeNotSatisfiable:  "Range Not Satisfiable",
+	ExpectationFailed:    "Expectation Failed",
+	TooManyRequests:      "Too Many Requests",
+
+	InternalError:      "Internal Server Error",
+	NotImplemented:     "Not Implemented",
+	BadGateway:         "Bad Gateway",
+	ServiceUnavailable: "Service Unavailable",
+	GatewayTimeout:     "Gateway Timeout",
+}
+
+// StatusText returns the reason phrase for a status code, or "" if unknown
+func StatusText(code StatusCode) string {
+	return statusText[code]
+}
+
 type Writer struct {
 	Buffer		io.Writer
+
+	status			StatusCode
+	headersWritten	bool
+	chunked			bool
+	bytesWritten	int
+	cookies			[]string
+
+	body		io.Writer
+	bodyClosers	[]io.Closer
+	headerHooks	[]func(StatusCode, headers.Headers)
+
+	// discard is set by DiscardBody; discarded counts the body bytes
+	// dropped and held keeps headers waiting for that count
+	discard		bool
+	discarded	int
+	held		headers.Headers
+
+	hijacker	func() (net.Conn, []byte, error)
+	hijacked	bool
 }
 
+var (
+	// ErrNotHijackable means the writer is not backed by a connection that
+	// can be taken over
+	ErrNotHijackable = errors.New("response writer does not support hijacking")
+	// ErrHijacked is returned by writes after the connection was hijacked
+	ErrHijacked = errors.New("connection has been hijacked")
+)
+
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	defaultHeaders := headers.NewHeaders()
 	defaultHeaders["content-length"] = strconv.Itoa(contentLen)
@@ -34,33 +147,285 @@ func NewWriter(w io.Writer) Writer{
 	}
 }
 
+// Status returns the status code written so far, or 0 if none
+func (w *Writer) Status() StatusCode {
+	return w.status
+}
+
+// BytesWritten returns the number of body bytes handed to the writer,
+// before any chunk framing or body wrapping. Bytes dropped by DiscardBody
+// are not counted.
+func (w *Writer) BytesWritten() int {
+	return w.bytesWritten
+}
+
+// OnHeaders registers fn to run just before the headers go out. Hooks may
+// modify the headers and run in the order they were registered.
+func (w *Writer) OnHeaders(fn func(status StatusCode, h headers.Headers)) {
+	w.headerHooks = append(w.headerHooks, fn)
+}
+
+// WrapBody routes every following body write through the writer fn
+// returns. fn receives the current body destination, so wrappers nest in
+// the order they are installed, the last one seeing the bytes first. A
+// wrapper that buffers, such as a compressor, should implement io.Closer
+// to flush itself when the body ends.
+func (w *Writer) WrapBody(fn func(io.Writer) io.Writer) {
+	w.body = fn(w.bodyWriter())
+	if c, ok := w.body.(io.Closer); ok {
+		w.bodyClosers = append(w.bodyClosers, c)
+	}
+}
+
+// CloseBody closes the body wrappers, latest first so that whatever each
+// one flushes still passes through the ones installed before it. It is called
+// by WriteChunkedBodyDone and is safe to call more than once.
+func (w *Writer) CloseBody() error {
+	var firstErr error
+	for i := len(w.bodyClosers) - 1; i >= 0; i-- {
+		if err := w.bodyClosers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	w.bodyClosers = nil
+	return firstErr
+}
+
+// DiscardBody makes the writer answer a HEAD request with what a handler
+// sends for GET: the status line and headers go out but body bytes are
+// dropped. Headers the handler sends without a Content-Length are held
+// back until FinishHead, which adds the length the body would have had.
+// Changes header hooks make to the framing, such as compression switching
+// to chunked, go out as they are, just as they would for GET.
+func (w *Writer) DiscardBody() {
+	w.discard = true
+}
+
+// FinishHead sends the headers held back by DiscardBody, if any
+func (w *Writer) FinishHead() error {
+	if w.held == nil {
+		return nil
+	}
+	h := w.held
+	w.held = nil
+	delete(h, "transfer-encoding")
+	delete(h, "trailer")
+	h["content-length"] = strconv.Itoa(w.discarded)
+	return w.writeFieldBlock(h)
+}
+
+func (w *Writer) bodyWriter() io.Writer {
+	if w.body != nil {
+		return w.body
+	}
+	return sinkWriter{w}
+}
+
+// sinkWriter is the end of the body pipeline, it frames chunks when the
+// response uses chunked transfer coding
+type sinkWriter struct {
+	w *Writer
+}
+
+func (s sinkWriter) Write(p []byte) (int, error) {
+	if s.w.discard {
+		s.w.discarded += len(p)
+		return len(p), nil
+	}
+	if !s.w.chunked {
+		return s.w.Buffer.Write(p)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	head := []byte(fmt.Sprintf("%x\r\n", len(p)))
+
+	// payload + \r\n
+	b := make([]byte, 0, len(head)+len(p)+2)
+	b = append(b, head...)
+	b = append(b, p...)
+	b = append(b, '\r', '\n')
+
+	if _, err := s.w.Buffer.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 func (w *Writer) WriteBody(p []byte) (int, error) {
-	w.Buffer.Write(p)
+	if !w.discard {
+		w.bytesWritten += len(p)
+	}
+	if _, err := w.bodyWriter().Write(p); err != nil {
+		return 0, err
+	}
 	return len(p), nil
 }
 
+// Write makes the Writer an io.Writer for the body, so it can be the
+// destination of io.Copy
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteBody(p)
+}
+
 func (w * Writer) WriteStatusLine(statusCode StatusCode) error {
-	switch statusCode {
-	case OK:
-		w.Buffer.Write([]byte("HTTP/1.1 200 OK\r\n"))
-		return nil
-	case BadRequest:
-		w.Buffer.Write([]byte("HTTP/1.1 400 Bad Request\r\n"))
-		return nil
-	case InternalError:
-		w.Buffer.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n"))
-		return nil
-	default:
+	text, ok := statusText[statusCode]
+	if !ok {
 		return fmt.Errorf("unknown status code")
 	}
+	w.status = statusCode
+	w.Buffer.Write(fmt.Appendf(nil, "HTTP/1.1 %d %s\r\n", statusCode, text))
+	return nil
+}
+
+// WriteStatusLineReason writes a status line for any three-digit code
+// with the given reason phrase, for relaying statuses this package has no
+// constant for, such as a proxied upstream's
+func (w *Writer) WriteStatusLineReason(statusCode StatusCode, reason string) error {
+	if statusCode < 100 || statusCode > 999 {
+		return fmt.Errorf("invalid status code: %d", statusCode)
+	}
+	if strings.ContainsAny(reason, "\r\n") {
+		return fmt.Errorf("invalid reason phrase: %q", reason)
+	}
+	w.status = statusCode
+	w.Buffer.Write(fmt.Appendf(nil, "HTTP/1.1 %d %s\r\n", statusCode, reason))
+	return nil
+}
+
+// WriteInterim sends an informational 1xx response ahead of the final
+// one, such as 103 Early Hints carrying Link headers for the client to
+// preload. It must come before the final status line, and cannot be 101,
+// which ends the HTTP exchange.
+func (w *Writer) WriteInterim(statusCode StatusCode, h headers.Headers) error {
+	if statusCode < 100 || statusCode > 199 || statusCode == SwitchingProtocols {
+		return fmt.Errorf("not an interim status code: %d", statusCode)
+	}
+	if w.status != 0 {
+		return fmt.Errorf("interim response after status line")
+	}
+	b := fmt.Appendf(nil, "HTTP/1.1 %d %s\r\n", statusCode, StatusText(statusCode))
+	b = appendFieldLines(b, h)
+	b = fmt.Append(b, "\r\n")
+	_, err := w.Buffer.Write(b)
+	return err
+}
+
+// SetHijacker lets Hijack hand over the connection the writer sends on.
+// fn returns the connection and any bytes already read from it past the
+// request. The server sets it; handlers have no use for it.
+func (w *Writer) SetHijacker(fn func() (net.Conn, []byte, error)) {
+	w.hijacker = fn
+}
+
+// Hijack takes over the connection the response was being written to.
+// The caller becomes responsible for closing it, and must first consume
+// the returned bytes, which the client sent after its request and which
+// were read along with it. The writer fails every write after a hijack.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.hijacker == nil {
+		return nil, nil, ErrNotHijackable
+	}
+	conn, buffered, err := w.hijacker()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	w.Buffer = hijackedWriter{}
+	return conn, buffered, nil
+}
+
+type hijackedWriter struct{}
+
+func (hijackedWriter) Write([]byte) (int, error) {
+	return 0, ErrHijacked
+}
+
+// SwitchProtocols answers an Upgrade request with 101 Switching Protocols
+// to protocol, along with h, and hijacks the connection for the new
+// protocol to be spoken on, see Hijack
+func (w *Writer) SwitchProtocols(protocol string, h headers.Headers) (net.Conn, []byte, error) {
+	if w.status != 0 {
+		return nil, nil, fmt.Errorf("status line already written")
+	}
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.status = SwitchingProtocols
+	header := headers.NewHeaders()
+	for key, value := range h {
+		header.Set(key, value)
+	}
+	header.Set("Connection", "Upgrade")
+	header.Set("Upgrade", protocol)
+	b := fmt.Appendf(nil, "HTTP/1.1 %d %s\r\n", SwitchingProtocols, StatusText(SwitchingProtocols))
+	b = appendFieldLines(b, header)
+	b = fmt.Append(b, "\r\n")
+	if _, err := conn.Write(b); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, buffered, nil
+}
+
+// WriteError writes a complete plain-text response for statusCode, with h
+// layered over the default headers
+func (w *Writer) WriteError(statusCode StatusCode, h headers.Headers) error {
+	body := fmt.Sprintf("%d %s\n", statusCode, StatusText(statusCode))
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	header := GetDefaultHeaders(len(body))
+	for key, value := range h {
+		header.Set(key, value)
+	}
+	if err := w.WriteHeaders(header); err != nil {
+		return err
+	}
+	_, err := w.WriteBody([]byte(body))
+	return err
 }
 
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
+	if w.headersWritten {
+		return fmt.Errorf("headers already written")
+	}
+	w.headersWritten = true
 
-	headerBytes := make([]byte, 0)
-	for key, value := range headers {
-		headerBytes = fmt.Appendf(headerBytes, "%s: %s\r\n", key, value)
+	_, hasLength := headers.Get("Content-Length")
+	for _, hook := range w.headerHooks {
+		hook(w.status, headers)
+	}
+	if w.discard {
+		if !hasLength && bodyAllowed(w.status) {
+			w.held = headers
+			return nil
+		}
+		return w.writeFieldBlock(headers)
+	}
+	if te, ok := headers.Get("Transfer-Encoding"); ok && strings.EqualFold(te, "chunked") {
+		w.chunked = true
 	}
+	return w.writeFieldBlock(headers)
+}
+
+// bodyAllowed reports whether a response with status may carry a body,
+// see RFC 9110 6.4.1
+func bodyAllowed(status StatusCode) bool {
+	return status >= 200 && status != NoContent && status != NotModified
+}
+
+// writeFieldBlock sends the header section, ending with the blank line
+func (w *Writer) writeFieldBlock(headers headers.Headers) error {
+	headerBytes := appendFieldLines(make([]byte, 0), headers)
+	for _, cookie := range w.cookies {
+		headerBytes = fmt.Appendf(headerBytes, "Set-Cookie: %s\r\n", cookie)
+	}
+	w.cookies = nil
 
 	headerBytes = fmt.Append(headerBytes, "\r\n")
 	w.Buffer.Write(headerBytes)
@@ -68,23 +433,36 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	return nil
 }
 
-func (w *Writer) WriteChunkedBody(p []byte) (int, error){
-	head := []byte(fmt.Sprintf("%x\r\n", len(p)))
-
-    // payload + \r\n
-    b := make([]byte, 0, len(head)+len(p)+2)
-    b = append(b, head...)
-    b = append(b, p...)
-    b = append(b, '\r', '\n')
+func appendFieldLines(b []byte, h headers.Headers) []byte {
+	for key, value := range h {
+		b = fmt.Appendf(b, "%s: %s\r\n", key, value)
+	}
+	return b
+}
 
-    return w.WriteBody(b)
+// WriteChunkedBody writes p as one chunk of a response whose headers
+// declared Transfer-Encoding: chunked
+func (w *Writer) WriteChunkedBody(p []byte) (int, error){
+	return w.WriteBody(p)
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error){
-	n, err := w.WriteBody([]byte("0\r\n"))
+	if err := w.CloseBody(); err != nil {
+		return 0, err
+	}
+	if w.discard {
+		return 0, nil
+	}
+	n, err := w.Buffer.Write([]byte("0\r\n"))
 	return n, err
 }
 
 func (w *Writer) WriteTrailers(h headers.Headers) error {
-	return w.WriteHeaders(h)
+	if w.discard {
+		return nil
+	}
+	trailerBytes := appendFieldLines(make([]byte, 0), h)
+	trailerBytes = fmt.Append(trailerBytes, "\r\n")
+	w.Buffer.Write(trailerBytes)
+	return nil
 }
@@ -0,0 +1,201 @@
+// Package router dispatches requests to handlers by method and path
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/colfarl/httpfromtcp/internal/server"
+)
+
+// Router matches request targets against registered patterns. A pattern
+// is an optional method followed by a path, e.g. "GET /users/{id}" or
+// "/static/{path...}"; patterns without a method match every method.
+// HEAD requests are served by the GET handler with the body discarded,
+// unless the path has a HEAD handler of its own. "OPTIONS *" is answered
+// with the methods the server supports.
+type Router struct {
+	root *node
+
+	// NotFound handles requests whose path matches no pattern
+	NotFound server.Handler
+}
+
+type route struct {
+	handlers map[string]server.Handler
+	any      server.Handler
+}
+
+func New() *Router {
+	return &Router{
+		root:     &node{},
+		NotFound: notFound,
+	}
+}
+
+// Handle registers h for pattern
+func (rt *Router) Handle(pattern string, h server.Handler) error {
+	if h == nil {
+		return fmt.Errorf("nil handler for pattern: %s", pattern)
+	}
+
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		method, path = "", pattern
+	}
+	path = strings.TrimLeft(path, " ")
+	if method != "" {
+		for _, c := range method {
+			if c < 'A' || c > 'Z' {
+				return fmt.Errorf("invalid method in pattern: %s", pattern)
+			}
+		}
+	}
+
+	segments, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	n, err := rt.root.insert(segments)
+	if err != nil {
+		return fmt.Errorf("%s: %w", pattern, err)
+	}
+
+	if n.route == nil {
+		n.route = &route{
+			handlers: make(map[string]server.Handler),
+		}
+	}
+	if method == "" {
+		if n.route.any != nil {
+			return fmt.Errorf("pattern %s already registered", pattern)
+		}
+		n.route.any = h
+		return nil
+	}
+	if _, ok := n.route.handlers[method]; ok {
+		return fmt.Errorf("pattern %s already registered", pattern)
+	}
+	n.route.handlers[method] = h
+	return nil
+}
+
+// Handler returns the router as a server.Handler
+func (rt *Router) Handler() server.Handler {
+	return rt.Serve
+}
+
+func (rt *Router) Serve(w *response.Writer, req *request.Request) {
+	if req.RequestLine.RequestTarget == "*" {
+		rt.serveAsterisk(w, req)
+		return
+	}
+	path := req.RequestLine.RequestTarget
+	if i := strings.IndexByte(path, '?'); i != -1 {
+		path = path[:i]
+	}
+
+	params := make([]pathParam, 0)
+	n := rt.root.lookup(path, &params)
+	method := req.RequestLine.Method
+	if method == "HEAD" && (n == nil || n.route.handlers["HEAD"] == nil) {
+		w.DiscardBody()
+		defer w.FinishHead()
+		method = "GET"
+	}
+	if n == nil {
+		rt.NotFound(w, req)
+		return
+	}
+
+	h, ok := n.route.handlers[method]
+	if !ok {
+		h = n.route.any
+	}
+	if h == nil {
+		allow := headers.NewHeaders()
+		allow.Set("Allow", strings.Join(n.route.methods(), ", "))
+		w.WriteError(response.MethodNotAllowed, allow)
+		return
+	}
+
+	for _, p := range params {
+		value, err := url.PathUnescape(p.value)
+		if err != nil {
+			value = p.value
+		}
+		req.SetPathValue(p.name, value)
+	}
+	h(w, req)
+}
+
+func (r *route) methods() []string {
+	methods := make([]string, 0, len(r.handlers))
+	for method := range r.handlers {
+		methods = append(methods, method)
+	}
+	if _, ok := r.handlers["GET"]; ok {
+		if _, ok := r.handlers["HEAD"]; !ok {
+			methods = append(methods, "HEAD")
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// commonMethods stand in for the methods a method-less route accepts
+var commonMethods = []string{"DELETE", "GET", "HEAD", "OPTIONS", "PATCH", "POST", "PUT"}
+
+// serveAsterisk answers requests for the server as a whole, of which
+// only OPTIONS is meaningful, see RFC 9110 9.3.7
+func (rt *Router) serveAsterisk(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method != "OPTIONS" {
+		w.WriteError(response.BadRequest, nil)
+		return
+	}
+	set := map[string]bool{"OPTIONS": true}
+	rt.root.collectMethods(set)
+	methods := make([]string, 0, len(set))
+	for method := range set {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	h := headers.NewHeaders()
+	h.Set("Allow", strings.Join(methods, ", "))
+	h.Set("Connection", "close")
+	w.WriteStatusLine(response.NoContent)
+	w.WriteHeaders(h)
+}
+
+// collectMethods adds the methods accepted anywhere under n to set
+func (n *node) collectMethods(set map[string]bool) {
+	if n.route != nil {
+		if n.route.any != nil {
+			for _, method := range commonMethods {
+				set[method] = true
+			}
+		}
+		for _, method := range n.route.methods() {
+			set[method] = true
+		}
+	}
+	for _, child := range n.children {
+		child.collectMethods(set)
+	}
+	if n.param != nil {
+		n.param.collectMethods(set)
+	}
+	if n.wildcard != nil {
+		n.wildcard.collectMethods(set)
+	}
+}
+
+func notFound(w *response.Writer, _ *request.Request) {
+	w.WriteError(response.NotFound, nil)
+}
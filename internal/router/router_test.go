@@ -0,0 +1,178 @@
+package router
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, rt *Router, method, target string) (string, *request.Request) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	rt.Serve(&w, req)
+	return buf.String(), req
+}
+
+func named(name string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		w.WriteBody([]byte(name))
+	}
+}
+
+func TestRouterDispatch(t *testing.T) {
+	rt := New()
+	require.NoError(t, rt.Handle("GET /users", named("list")))
+	require.NoError(t, rt.Handle("POST /users", named("create")))
+	require.NoError(t, rt.Handle("GET /users/me", named("me")))
+	require.NoError(t, rt.Handle("GET /users/{id}", named("user")))
+	require.NoError(t, rt.Handle("GET /users/{id}/posts/{post}", named("post")))
+	require.NoError(t, rt.Handle("/static/{path...}", named("static")))
+	require.NoError(t, rt.Handle("GET /up", named("up")))
+	require.NoError(t, rt.Handle("GET /upload", named("upload")))
+
+	// Test: Static routes
+	out, _ := serve(t, rt, "GET", "/users")
+	assert.Equal(t, "list", out)
+	out, _ = serve(t, rt, "POST", "/users")
+	assert.Equal(t, "create", out)
+	out, _ = serve(t, rt, "GET", "/up")
+	assert.Equal(t, "up", out)
+	out, _ = serve(t, rt, "GET", "/upload?x=1")
+	assert.Equal(t, "upload", out)
+
+	// Test: Static segments win over wildcards
+	out, _ = serve(t, rt, "GET", "/users/me")
+	assert.Equal(t, "me", out)
+
+	// Test: Named wildcards
+	out, req := serve(t, rt, "GET", "/users/42")
+	assert.Equal(t, "user", out)
+	assert.Equal(t, "42", req.PathValue("id"))
+
+	out, req = serve(t, rt, "GET", "/users/a%20b/posts/7")
+	assert.Equal(t, "post", out)
+	assert.Equal(t, "a b", req.PathValue("id"))
+	assert.Equal(t, "7", req.PathValue("post"))
+
+	// Test: Catch-all wildcard matches any method and the empty remainder
+	out, req = serve(t, rt, "DELETE", "/static/css/site.css")
+	assert.Equal(t, "static", out)
+	assert.Equal(t, "css/site.css", req.PathValue("path"))
+	out, req = serve(t, rt, "GET", "/static/")
+	assert.Equal(t, "static", out)
+	assert.Equal(t, "", req.PathValue("path"))
+
+	// Test: Unknown path
+	out, _ = serve(t, rt, "GET", "/nope")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+	out, _ = serve(t, rt, "GET", "/users/42/posts")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Known path, wrong method
+	out, _ = serve(t, rt, "DELETE", "/users")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, out, "allow: GET, HEAD, POST\r\n")
+}
+
+func TestRouterHandleErrors(t *testing.T) {
+	rt := New()
+	require.NoError(t, rt.Handle("GET /a/{id}", named("a")))
+
+	// Test: Duplicate registration
+	require.Error(t, rt.Handle("GET /a/{id}", named("a")))
+
+	// Test: Conflicting wildcard names
+	require.Error(t, rt.Handle("GET /a/{name}/b", named("a")))
+
+	// Test: Malformed patterns
+	require.Error(t, rt.Handle("GET a", named("a")))
+	require.Error(t, rt.Handle("get /a", named("a")))
+	require.Error(t, rt.Handle("/b/x{id}", named("a")))
+	require.Error(t, rt.Handle("/b/{id", named("a")))
+	require.Error(t, rt.Handle("/b/{rest...}/c", named("a")))
+	require.Error(t, rt.Handle("/b/{id}/{id}", named("a")))
+	require.Error(t, rt.Handle("/b", nil))
+}
+
+func TestRouterHead(t *testing.T) {
+	rt := New()
+	page := func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.OK)
+		h := response.GetDefaultHeaders(5)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte("hello"))
+	}
+	stream := func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.OK)
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Checksum")
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("some "))
+		w.WriteChunkedBody([]byte("chunks"))
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(headers.Headers{"x-checksum": "abc"})
+	}
+	require.NoError(t, rt.Handle("GET /page", page))
+	require.NoError(t, rt.Handle("GET /stream", stream))
+	require.NoError(t, rt.Handle("/any", page))
+	require.NoError(t, rt.Handle("GET /own", page))
+	require.NoError(t, rt.Handle("HEAD /own", named("own head")))
+
+	// Test: HEAD runs the GET handler and drops the body
+	out, _ := serve(t, rt, "HEAD", "/page")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+	assert.NotContains(t, out, "hello")
+
+	// Test: A streamed body's length is counted in place of chunking
+	out, _ = serve(t, rt, "HEAD", "/stream")
+	assert.Contains(t, out, "content-length: 11\r\n")
+	assert.NotContains(t, out, "transfer-encoding")
+	assert.NotContains(t, out, "chunks")
+	assert.NotContains(t, out, "x-checksum")
+
+	// Test: Method-less patterns are answered the same way
+	out, _ = serve(t, rt, "HEAD", "/any")
+	assert.Contains(t, out, "content-length: 5\r\n")
+	assert.NotContains(t, out, "hello")
+
+	// Test: An explicit HEAD handler is used as is
+	out, _ = serve(t, rt, "HEAD", "/own")
+	assert.Equal(t, "own head", out)
+
+	// Test: Error responses to HEAD carry no body either
+	out, _ = serve(t, rt, "HEAD", "/nope")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+}
+
+func TestRouterOptionsAsterisk(t *testing.T) {
+	rt := New()
+	require.NoError(t, rt.Handle("GET /users", named("list")))
+	require.NoError(t, rt.Handle("POST /users/{id}", named("update")))
+
+	// Test: OPTIONS * lists every method the routes accept
+	out, _ := serve(t, rt, "OPTIONS", "*")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"))
+	assert.Contains(t, out, "allow: GET, HEAD, OPTIONS, POST\r\n")
+
+	// Test: Method-less routes add the common methods
+	require.NoError(t, rt.Handle("/{path...}", named("any")))
+	out, _ = serve(t, rt, "OPTIONS", "*")
+	assert.Contains(t, out, "allow: DELETE, GET, HEAD, OPTIONS, PATCH, POST, PUT\r\n")
+
+	// Test: Other methods cannot target the server as a whole
+	out, _ = serve(t, rt, "GET", "*")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+}
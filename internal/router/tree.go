@@ -0,0 +1,205 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+)
+
+// node is a vertex of the radix tree. Static children share no common
+// leading byte, so at most one of them can match any given path. Named
+// wildcards ({id}) and catch-alls ({path...}) hang off their own slots and
+// are only tried after the static children fail.
+type node struct {
+	prefix   string
+	children []*node
+
+	param    *node
+	wildcard *node
+	name     string
+
+	route *route
+}
+
+type segment struct {
+	static   string
+	name     string
+	wildcard bool
+}
+
+type pathParam struct {
+	name  string
+	value string
+}
+
+// parsePath splits a route path into static text and wildcard segments
+func parsePath(path string) ([]segment, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path must begin with '/': %s", path)
+	}
+
+	segments := make([]segment, 0)
+	seen := make(map[string]struct{})
+	static := ""
+	for i := 0; i < len(path); {
+		if path[i] != '{' {
+			end := strings.IndexByte(path[i:], '{')
+			if end == -1 {
+				end = len(path) - i
+			}
+			static += path[i : i+end]
+			i += end
+			continue
+		}
+
+		end := strings.IndexByte(path[i:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated wildcard in path: %s", path)
+		}
+		if path[i-1] != '/' || (i+end+1 < len(path) && path[i+end+1] != '/') {
+			return nil, fmt.Errorf("wildcard must be a full path segment: %s", path)
+		}
+
+		name := path[i+1 : i+end]
+		seg := segment{}
+		if strings.HasSuffix(name, "...") {
+			if i+end+1 != len(path) {
+				return nil, fmt.Errorf("catch-all wildcard must be at the end of the path: %s", path)
+			}
+			name = strings.TrimSuffix(name, "...")
+			seg.wildcard = true
+		}
+		if name == "" || strings.ContainsAny(name, "{}/") {
+			return nil, fmt.Errorf("invalid wildcard name in path: %s", path)
+		}
+		if _, ok := seen[name]; ok {
+			return nil, fmt.Errorf("duplicate wildcard name %q in path: %s", name, path)
+		}
+		seen[name] = struct{}{}
+
+		if static != "" {
+			segments = append(segments, segment{static: static})
+			static = ""
+		}
+		seg.name = name
+		segments = append(segments, seg)
+		i += end + 1
+	}
+	if static != "" {
+		segments = append(segments, segment{static: static})
+	}
+	return segments, nil
+}
+
+// insert walks or grows the tree along segments and returns the node at
+// which the path ends
+func (n *node) insert(segments []segment) (*node, error) {
+	current := n
+	for _, seg := range segments {
+		var err error
+		switch {
+		case seg.static != "":
+			current = current.insertStatic(seg.static)
+		case seg.wildcard:
+			current, err = current.child(&current.wildcard, seg.name)
+		default:
+			current, err = current.child(&current.param, seg.name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return current, nil
+}
+
+func (n *node) child(slot **node, name string) (*node, error) {
+	if *slot == nil {
+		*slot = &node{name: name}
+	}
+	if (*slot).name != name {
+		return nil, fmt.Errorf("wildcard {%s} conflicts with existing wildcard {%s}", name, (*slot).name)
+	}
+	return *slot, nil
+}
+
+func (n *node) insertStatic(path string) *node {
+	for path != "" {
+		var next *node
+		for _, c := range n.children {
+			if c.prefix[0] == path[0] {
+				next = c
+				break
+			}
+		}
+
+		if next == nil {
+			leaf := &node{prefix: path}
+			n.children = append(n.children, leaf)
+			return leaf
+		}
+
+		common := commonPrefixLen(next.prefix, path)
+		if common < len(next.prefix) {
+			// split the existing edge so the shared prefix becomes its own node
+			tail := *next
+			tail.prefix = next.prefix[common:]
+			*next = node{prefix: next.prefix[:common], children: []*node{&tail}}
+		}
+		n = next
+		path = path[common:]
+	}
+	return n
+}
+
+func commonPrefixLen(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// lookup finds the node whose route matches path, preferring static
+// matches over named wildcards over catch-alls, and records the captured
+// values in params
+func (n *node) lookup(path string, params *[]pathParam) *node {
+	if path == "" {
+		if n.route != nil {
+			return n
+		}
+		if n.wildcard != nil && n.wildcard.route != nil {
+			*params = append(*params, pathParam{name: n.wildcard.name})
+			return n.wildcard
+		}
+		return nil
+	}
+
+	for _, c := range n.children {
+		if strings.HasPrefix(path, c.prefix) {
+			if found := c.lookup(path[len(c.prefix):], params); found != nil {
+				return found
+			}
+			break
+		}
+	}
+
+	if n.param != nil {
+		end := strings.IndexByte(path, '/')
+		if end == -1 {
+			end = len(path)
+		}
+		if end > 0 {
+			mark := len(*params)
+			*params = append(*params, pathParam{name: n.param.name, value: path[:end]})
+			if found := n.param.lookup(path[end:], params); found != nil {
+				return found
+			}
+			*params = (*params)[:mark]
+		}
+	}
+
+	if n.wildcard != nil && n.wildcard.route != nil {
+		*params = append(*params, pathParam{name: n.wildcard.name, value: path})
+		return n.wildcard
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first descriptor passed under the LISTEN_FDS
+// protocol, right after stdin, stdout and stderr
+const listenFDsStart = 3
+
+// readyFDEnv names the descriptor a handed-off child writes to once it is
+// serving, so the parent knows it is safe to drain
+const readyFDEnv = "HTTPFROMTCP_READY_FD"
+
+// InheritedListeners returns the listening sockets passed in by systemd
+// socket activation, or by a parent process through Handoff, in the order
+// they were passed. It returns no listeners when the process was started
+// normally. The LISTEN_* variables are cleared so that child processes do
+// not try to claim the same sockets.
+//
+// systemd sets LISTEN_PID to the pid of the activated process; when it is
+// absent the sockets are assumed to be meant for this process, which is
+// how Handoff passes them since a child's pid is unknown before it starts.
+func InheritedListeners() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	countStr := os.Getenv("LISTEN_FDS")
+	if countStr == "" {
+		return nil, nil
+	}
+	if pidStr := os.Getenv("LISTEN_PID"); pidStr != "" {
+		pid, err := strconv.Atoi(pidStr)
+		if err != nil {
+			return nil, fmt.Errorf("malformed LISTEN_PID: %s", pidStr)
+		}
+		if pid != os.Getpid() {
+			return nil, nil
+		}
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("malformed LISTEN_FDS: %s", countStr)
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]net.Listener, 0, count)
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("LISTEN_FD_%d", listenFDsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		// FileListener dups the descriptor, so the inherited one is closed
+		// either way and does not leak into processes we start later
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("inherited descriptor %d: %w", listenFDsStart+i, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// NotifyReady tells the parent that started this process through Handoff
+// that it is serving. It is a no-op for processes started any other way.
+func NotifyReady() error {
+	fdStr := os.Getenv(readyFDEnv)
+	if fdStr == "" {
+		return nil
+	}
+	os.Unsetenv(readyFDEnv)
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return fmt.Errorf("malformed %s: %s", readyFDEnv, fdStr)
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	_, err = f.Write([]byte{1})
+	return err
+}
+
+// Handoff re-executes the running binary with the same arguments, passing
+// it this server's listening socket through the LISTEN_FDS protocol, and
+// waits up to timeout for the child to call NotifyReady. On success the
+// caller should drain this server with Shutdown; the socket stays open in
+// the child, so no connection is refused in between. If the child fails
+// to start or become ready, it is killed and this server keeps serving.
+func (s *Server) Handoff(timeout time.Duration) (*os.Process, error) {
+	filer, ok := s.Listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("listener %T cannot be handed off", s.Listener)
+	}
+	listenerFile, err := filer.File()
+	if err != nil {
+		return nil, err
+	}
+	defer listenerFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyR.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return nil, err
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// ExtraFiles[i] becomes descriptor 3+i in the child
+	cmd.ExtraFiles = []*os.File{listenerFile, readyW}
+	cmd.Env = append(handoffEnviron(),
+		"LISTEN_FDS=1",
+		"LISTEN_FDNAMES=handoff",
+		fmt.Sprintf("%s=%d", readyFDEnv, listenFDsStart+1),
+	)
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyR.Read(buf)
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+	case <-time.After(timeout):
+		err = fmt.Errorf("child did not become ready within %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("handoff failed: %w", err)
+	}
+
+	// the child owns the socket path from here on
+	if ul, ok := s.Listener.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	go cmd.Wait()
+	return cmd.Process, nil
+}
+
+func handoffEnviron() []string {
+	env := make([]string, 0)
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		switch key {
+		case "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", readyFDEnv:
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+)
+
+// WithMaxBodySize refuses requests that declare a body larger than n bytes
+// with 413, before any of it is read
+func WithMaxBodySize(n int64) Option {
+	return func(s *Server) {
+		s.maxBodySize = n
+	}
+}
+
+// refusalTimeout bounds how long answering a refused request may take,
+// draining its body included
+const refusalTimeout = time.Second
+
+// refusal ends a request whose headers alone decide the response
+type refusal struct {
+	status response.StatusCode
+}
+
+func (r *refusal) Error() string {
+	return fmt.Sprintf("request refused with %d %s", r.status, response.StatusText(r.status))
+}
+
+// vetHeaders returns the hook run between a request's headers and its
+// body. Unknown expectations get 417, bodies over the size limit 413 and
+// bodies with a Transfer-Encoding, which the parser cannot read, 411 so
+// the client resends them with a Content-Length. A client waiting on
+// "Expect: 100-continue" is then told to send its body, see RFC 9110
+// 10.1.1. That happens here, before the handler runs, rather than once
+// it starts reading the body.
+func (s *Server) vetHeaders(conn net.Conn) func(*request.Request) error {
+	return func(r *request.Request) error {
+		expect, expecting := r.Headers.Get("Expect")
+		if expecting && !strings.EqualFold(strings.TrimSpace(expect), "100-continue") {
+			return &refusal{response.ExpectationFailed}
+		}
+		if _, ok := r.Headers.Get("Transfer-Encoding"); ok {
+			return &refusal{response.LengthRequired}
+		}
+		length := int64(0)
+		if value, ok := r.Headers.Get("Content-Length"); ok {
+			length, _ = strconv.ParseInt(value, 10, 64)
+		}
+		if s.maxBodySize > 0 && length > s.maxBodySize {
+			return &refusal{response.ContentTooLarge}
+		}
+		if expecting && length > 0 {
+			w := response.NewWriter(conn)
+			return w.WriteInterim(response.Continue, nil)
+		}
+		return nil
+	}
+}
+
+// refuse answers a request vetHeaders refused. The body the client may
+// already be sending is drained before the connection closes.
+func refuse(conn net.Conn, status response.StatusCode) {
+	conn.SetDeadline(time.Now().Add(refusalTimeout))
+	w := response.NewWriter(conn)
+	w.WriteError(status, nil)
+	drain(conn)
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(req.Body)))
+	w.WriteBody(req.Body)
+}
+
+func TestExpectContinue(t *testing.T) {
+	s, err := Serve(0, echoHandler, WithMaxBodySize(16))
+	require.NoError(t, err)
+	defer s.Close()
+	dial := func(head string) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", s.Listener.Addr().String())
+		require.NoError(t, err)
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		_, err = conn.Write([]byte(head))
+		require.NoError(t, err)
+		return conn, bufio.NewReader(conn)
+	}
+
+	// Test: The body is sent only after 100 Continue
+	conn, r := dial("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	defer conn.Close()
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n", line)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", line)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	resp, err := response.ResponseFromReader(r, "POST")
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, resp.StatusLine.StatusCode)
+	assert.Equal(t, "hello", string(resp.Body))
+
+	// Test: A body over the limit is refused before it is sent
+	conn, r = dial("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 17\r\n\r\n")
+	defer conn.Close()
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 413 Content Too Large\r\n"), string(out))
+
+	// Test: A body sent without waiting is drained, so the refusal arrives
+	// instead of a reset
+	conn, r = dial("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 65536\r\n\r\n")
+	defer conn.Close()
+	go conn.Write([]byte(strings.Repeat("x", 65536)))
+	out, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 413 Content Too Large\r\n"), string(out))
+
+	// Test: A chunked body is refused rather than dropped, without a
+	// Continue first
+	conn, r = dial("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nTransfer-Encoding: chunked\r\n\r\n")
+	defer conn.Close()
+	out, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 411 Length Required\r\n"), string(out))
+
+	// Test: Unknown expectations fail
+	conn, r = dial("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: something-else\r\nContent-Length: 5\r\n\r\n")
+	defer conn.Close()
+	out, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 417 Expectation Failed\r\n"), string(out))
+
+	// Test: No Continue for a request without a body
+	conn, r = dial("GET / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\n\r\n")
+	defer conn.Close()
+	out, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"), string(out))
+}
+
+func TestInterimResponses(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		hints := headers.NewHeaders()
+		hints.Set("Link", "</style.css>; rel=preload; as=style")
+		require.NoError(t, w.WriteInterim(response.EarlyHints, hints))
+		assert.Error(t, w.WriteInterim(response.SwitchingProtocols, nil))
+		assert.Error(t, w.WriteInterim(response.OK, nil))
+		w.WriteError(response.NotFound, nil)
+		assert.Error(t, w.WriteInterim(response.EarlyHints, hints))
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Early hints arrive before the final response
+	conn := sendRequest(t, s)
+	defer conn.Close()
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 103 Early Hints\r\nlink: </style.css>; rel=preload; as=style\r\n\r\nHTTP/1.1 404 Not Found\r\n"), string(out))
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upgradeHandler switches to a line echo protocol and hands the connection
+// to a goroutine that outlives the handler
+func upgradeHandler(t *testing.T, hijacked chan<- net.Conn) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if !req.WantsUpgrade("echo") {
+			w.WriteError(response.BadRequest, nil)
+			return
+		}
+		h := headers.NewHeaders()
+		h.Set("X-Echo", "1")
+		conn, buffered, err := w.SwitchProtocols("echo", h)
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = w.Write([]byte("too late"))
+		assert.ErrorIs(t, err, response.ErrHijacked)
+		_, _, err = w.Hijack()
+		assert.ErrorIs(t, err, response.ErrHijacked)
+
+		hijacked <- conn
+		go func() {
+			r := bufio.NewReader(io.MultiReader(strings.NewReader(string(buffered)), conn))
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				conn.Write([]byte("echo: " + line))
+			}
+		}()
+	}
+}
+
+func TestHijack(t *testing.T) {
+	hijacked := make(chan net.Conn, 1)
+	s, err := Serve(0, upgradeHandler(t, hijacked))
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// Test: Bytes sent along with the request reach the new protocol
+	_, err = conn.Write([]byte("GET /chat HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nfirst\n"))
+	require.NoError(t, err)
+	// the head is read line by line, since the echo follows right after it
+	r := bufio.NewReader(conn)
+	head := ""
+	for !strings.HasSuffix(head, "\r\n\r\n") {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		head += line
+	}
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 101 Switching Protocols\r\n"), head)
+	assert.Contains(t, head, "upgrade: echo\r\n")
+	assert.Contains(t, head, "connection: Upgrade\r\n")
+	assert.Contains(t, head, "x-echo: 1\r\n")
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: first\n", line)
+
+	// Test: The connection outlives the handler and is no longer tracked
+	serverSide := <-hijacked
+	defer serverSide.Close()
+	_, err = conn.Write([]byte("second\n"))
+	require.NoError(t, err)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: second\n", line)
+	assert.Equal(t, 0, s.activeConns())
+
+	// Test: Shutting the server down leaves it open
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	_, err = conn.Write([]byte("third\n"))
+	require.NoError(t, err)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: third\n", line)
+}
+
+func TestHijackUnsupported(t *testing.T) {
+	// Test: A writer without a connection behind it cannot be hijacked
+	w := response.NewWriter(io.Discard)
+	_, _, err := w.Hijack()
+	assert.ErrorIs(t, err, response.ErrNotHijackable)
+	_, _, err = w.SwitchProtocols("websocket", nil)
+	assert.ErrorIs(t, err, response.ErrNotHijackable)
+}
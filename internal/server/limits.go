@@ -0,0 +1,196 @@
+package server
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/response"
+)
+
+// Limits bounds how much work the server takes on at once. Zero values
+// mean unlimited.
+type Limits struct {
+	// MaxConns caps the connections being served concurrently
+	MaxConns int
+	// MaxRequests caps the handlers running concurrently; requests over
+	// the cap are answered with 503 straight away
+	MaxRequests int
+
+	// QueueSize is how many connections may wait for a free slot once
+	// MaxConns is reached, and QueueTimeout how long each may wait, zero
+	// for as long as it takes. A connection that finds the queue full or
+	// times out gets a 503.
+	QueueSize    int
+	QueueTimeout time.Duration
+
+	// RetryAfter is advertised on 503 responses, one second by default
+	RetryAfter time.Duration
+}
+
+// Stats counts what the server has done with the connections it accepted
+type Stats struct {
+	Accepted uint64
+	Active   int64
+	Queued   int64
+	// ShedConns counts connections turned away by MaxConns and the queue
+	ShedConns uint64
+	// ShedRequests counts requests turned away by MaxRequests
+	ShedRequests uint64
+}
+
+type limiter struct {
+	Limits
+	conns    chan struct{}
+	requests chan struct{}
+
+	accepted     atomic.Uint64
+	active       atomic.Int64
+	queued       atomic.Int64
+	shedConns    atomic.Uint64
+	shedRequests atomic.Uint64
+}
+
+// shedTimeout bounds how long rejecting an overflow connection may take
+const shedTimeout = time.Second
+
+// WithLimits caps concurrent connections and requests
+func WithLimits(limits Limits) Option {
+	return func(s *Server) {
+		s.limits = newLimiter(limits)
+	}
+}
+
+func newLimiter(limits Limits) *limiter {
+	l := &limiter{Limits: limits}
+	if l.MaxConns > 0 {
+		l.conns = make(chan struct{}, l.MaxConns)
+	}
+	if l.MaxRequests > 0 {
+		l.requests = make(chan struct{}, l.MaxRequests)
+	}
+	if l.RetryAfter <= 0 {
+		l.RetryAfter = time.Second
+	}
+	return l
+}
+
+// Stats returns a snapshot of the server's connection counters
+func (s *Server) Stats() Stats {
+	return Stats{
+		Accepted:     s.limits.accepted.Load(),
+		Active:       s.limits.active.Load(),
+		Queued:       s.limits.queued.Load(),
+		ShedConns:    s.limits.shedConns.Load(),
+		ShedRequests: s.limits.shedRequests.Load(),
+	}
+}
+
+// admit decides what happens to a freshly accepted connection. It returns
+// true when conn may be served right away; otherwise it has either queued
+// conn, calling serve once a slot frees up, or shed it. dropped runs once
+// conn has been closed without being served.
+func (l *limiter) admit(conn net.Conn, serve, dropped func(), done <-chan struct{}) bool {
+	l.accepted.Add(1)
+	if l.conns == nil {
+		return true
+	}
+	select {
+	case l.conns <- struct{}{}:
+		return true
+	default:
+	}
+
+	if l.queued.Add(1) > int64(l.QueueSize) {
+		l.queued.Add(-1)
+		l.shedConns.Add(1)
+		go func() {
+			l.shed(conn)
+			dropped()
+		}()
+		return false
+	}
+	go func() {
+		var timeout <-chan time.Time
+		if l.QueueTimeout > 0 {
+			timer := time.NewTimer(l.QueueTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case l.conns <- struct{}{}:
+			l.queued.Add(-1)
+			serve()
+		case <-timeout:
+			l.queued.Add(-1)
+			l.shedConns.Add(1)
+			l.shed(conn)
+			dropped()
+		case <-done:
+			l.queued.Add(-1)
+			conn.Close()
+			dropped()
+		}
+	}()
+	return false
+}
+
+// releaseConn frees the slot taken by a served connection
+func (l *limiter) releaseConn() {
+	if l.conns != nil {
+		<-l.conns
+	}
+}
+
+// acquireRequest takes an in-flight request slot without waiting
+func (l *limiter) acquireRequest() bool {
+	if l.requests == nil {
+		return true
+	}
+	select {
+	case l.requests <- struct{}{}:
+		return true
+	default:
+		l.shedRequests.Add(1)
+		return false
+	}
+}
+
+func (l *limiter) releaseRequest() {
+	if l.requests != nil {
+		<-l.requests
+	}
+}
+
+func (l *limiter) unavailable(w *response.Writer) {
+	h := headers.NewHeaders()
+	h.Set("Retry-After", strconv.Itoa(int((l.RetryAfter+time.Second-1)/time.Second)))
+	w.WriteError(response.ServiceUnavailable, h)
+}
+
+// shed answers conn with a 503 without reading its request. Whatever the
+// client already sent is drained afterwards, because closing a socket with
+// unread data makes the kernel reset it and the 503 can get lost.
+func (l *limiter) shed(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(shedTimeout))
+	w := response.NewWriter(conn)
+	l.unavailable(&w)
+	drain(conn)
+}
+
+// drainLimit caps how much a connection that is being turned away is read
+const drainLimit = 256 << 10
+
+// drain half-closes conn and reads what the client is still sending, up
+// to drainLimit bytes or conn's deadline, so that closing it afterwards
+// does not reset it before the response is read
+func drain(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	io.CopyN(io.Discard, conn, drainLimit)
+}
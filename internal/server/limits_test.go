@@ -0,0 +1,140 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatedHandler blocks every request until release is closed
+func gatedHandler(started chan<- struct{}, release <-chan struct{}) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteError(response.OK, nil)
+	}
+}
+
+func readAll(t *testing.T, s *Server) string {
+	t.Helper()
+	conn := sendRequest(t, s)
+	defer conn.Close()
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestLimitsShedConnections(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	s, err := Serve(0, gatedHandler(started, release), WithLimits(Limits{MaxConns: 1, RetryAfter: 1500 * time.Millisecond}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	first := sendRequest(t, s)
+	defer first.Close()
+	<-started
+
+	// Test: Connections over the limit are rejected with 503
+	out := readAll(t, s)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Contains(t, out, "retry-after: 2\r\n")
+
+	close(release)
+	out2, err := io.ReadAll(first)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out2), "HTTP/1.1 200 OK\r\n"))
+
+	stats := s.Stats()
+	assert.Equal(t, uint64(2), stats.Accepted)
+	assert.Equal(t, uint64(1), stats.ShedConns)
+	assert.Equal(t, uint64(0), stats.ShedRequests)
+}
+
+func TestLimitsQueue(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	s, err := Serve(0, gatedHandler(started, release), WithLimits(Limits{MaxConns: 1, QueueSize: 1, QueueTimeout: 2 * time.Second}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	first := sendRequest(t, s)
+	defer first.Close()
+	<-started
+
+	// Test: Queued connection is served once a slot frees up
+	second := sendRequest(t, s)
+	defer second.Close()
+	require.Eventually(t, func() bool { return s.Stats().Queued == 1 }, time.Second, 5*time.Millisecond)
+
+	// Test: Connections beyond the queue are rejected
+	out := readAll(t, s)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"))
+
+	close(release)
+	out2, err := io.ReadAll(second)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out2), "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, int64(0), s.Stats().Queued)
+	assert.Equal(t, uint64(1), s.Stats().ShedConns)
+
+	// Test: Queued connections time out
+	started = make(chan struct{}, 2)
+	release = make(chan struct{})
+	defer close(release)
+	s2, err := Serve(0, gatedHandler(started, release), WithLimits(Limits{MaxConns: 1, QueueSize: 1, QueueTimeout: 50 * time.Millisecond}))
+	require.NoError(t, err)
+	defer s2.Close()
+	third := sendRequest(t, s2)
+	defer third.Close()
+	<-started
+	out = readAll(t, s2)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"))
+
+	// Test: Without a timeout queued connections wait for a slot
+	started = make(chan struct{}, 2)
+	release = make(chan struct{})
+	s3, err := Serve(0, gatedHandler(started, release), WithLimits(Limits{MaxConns: 1, QueueSize: 1}))
+	require.NoError(t, err)
+	defer s3.Close()
+	fourth := sendRequest(t, s3)
+	defer fourth.Close()
+	<-started
+	fifth := sendRequest(t, s3)
+	defer fifth.Close()
+	require.Eventually(t, func() bool { return s3.Stats().Queued == 1 }, time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(1), s3.Stats().Queued)
+	close(release)
+	out3, err := io.ReadAll(fifth)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out3), "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, uint64(0), s3.Stats().ShedConns)
+}
+
+func TestLimitsShedRequests(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	s, err := Serve(0, gatedHandler(started, release), WithLimits(Limits{MaxRequests: 1}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	first := sendRequest(t, s)
+	defer first.Close()
+	<-started
+
+	// Test: Requests over the in-flight limit are rejected with 503
+	out := readAll(t, s)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Contains(t, out, "retry-after: 1\r\n")
+	close(release)
+
+	assert.Equal(t, uint64(1), s.Stats().ShedRequests)
+	assert.Equal(t, uint64(0), s.Stats().ShedConns)
+}
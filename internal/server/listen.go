@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// WithSocketMode sets the file permissions of Unix sockets the server
+// creates, e.g. 0660 to restrict access to a group
+func WithSocketMode(mode os.FileMode) Option {
+	return func(s *Server) {
+		s.socketMode = mode
+	}
+}
+
+// WithStaleSocketRemoval removes a leftover Unix socket file before
+// listening if no process is accepting on it anymore
+func WithStaleSocketRemoval() Option {
+	return func(s *Server) {
+		s.removeStale = true
+	}
+}
+
+func parseAddr(addr string) (network, address string, err error) {
+	network, address, found := strings.Cut(addr, "://")
+	if !found {
+		return "tcp", addr, nil
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return network, address, nil
+	case "unix":
+		if address == "" || address == "@" {
+			return "", "", fmt.Errorf("missing socket path: %s", addr)
+		}
+		return network, address, nil
+	default:
+		return "", "", fmt.Errorf("unsupported network: %s", network)
+	}
+}
+
+func (s *Server) listenAddr(addr string) (net.Listener, error) {
+	network, address, err := parseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+
+	abstract := strings.HasPrefix(address, "@")
+	if !abstract && s.removeStale {
+		if err := removeStaleSocket(address); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if !abstract && s.socketMode != 0 {
+		if err := os.Chmod(address, s.socketMode); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// removeStaleSocket deletes path if it is a socket nobody is listening on.
+// Sockets that still accept connections and non-socket files are left
+// alone so that a misconfigured path cannot clobber them.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,14 @@
+package server
+
+// Middleware wraps a Handler to add behaviour around it, such as logging
+// or authentication
+type Middleware func(Handler) Handler
+
+// Chain wraps h in mws so that the first middleware is the outermost and
+// sees the request first
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
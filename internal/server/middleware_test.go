@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/colfarl/httpfromtcp/internal/headers"
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingWriter struct {
+	io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return c.Writer.Write(p)
+}
+
+func TestChain(t *testing.T) {
+	order := make([]string, 0)
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *response.Writer, req *request.Request) {
+				order = append(order, name+" in")
+				next(w, req)
+				order = append(order, name+" out")
+			}
+		}
+	}
+
+	var status response.StatusCode
+	var written, wire int
+	observe := func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			counter := &countingWriter{}
+			w.WrapBody(func(dst io.Writer) io.Writer {
+				counter.Writer = dst
+				return counter
+			})
+			w.OnHeaders(func(_ response.StatusCode, h headers.Headers) {
+				h.Set("X-Observed", "yes")
+			})
+			next(w, req)
+			status = w.Status()
+			written = w.BytesWritten()
+			wire = counter.n
+		}
+	}
+
+	h := Chain(func(w *response.Writer, req *request.Request) {
+		order = append(order, "handler")
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.NotFound)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello"))
+		w.WriteChunkedBody([]byte(" world"))
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(headers.NewHeaders())
+	}, trace("outer"), observe, trace("inner"))
+
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	h(&w, req)
+
+	assert.Equal(t, []string{"outer in", "inner in", "handler", "inner out", "outer out"}, order)
+	assert.Equal(t, response.NotFound, status)
+	assert.Equal(t, 11, written)
+	assert.Equal(t, 11, wire)
+	assert.Contains(t, buf.String(), "x-observed: yes\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"))
+}
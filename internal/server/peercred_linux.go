@@ -0,0 +1,36 @@
+//go:build linux
+
+package server
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/colfarl/httpfromtcp/internal/request"
+)
+
+// peerCred reads SO_PEERCRED from Unix socket connections
+func peerCred(conn net.Conn) *request.PeerCred {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return nil
+	}
+	return &request.PeerCred{
+		PID: cred.Pid,
+		UID: cred.Uid,
+		GID: cred.Gid,
+	}
+}
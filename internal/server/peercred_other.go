@@ -0,0 +1,14 @@
+//go:build !linux
+
+package server
+
+import (
+	"net"
+
+	"github.com/colfarl/httpfromtcp/internal/request"
+)
+
+// peerCred is only implemented on Linux
+func peerCred(conn net.Conn) *request.PeerCred {
+	return nil
+}
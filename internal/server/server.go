@@ -2,10 +2,17 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/colfarl/httpfromtcp/internal/request"
 	"github.com/colfarl/httpfromtcp/internal/response"
@@ -19,27 +26,158 @@ type HandlerError struct {
 type Server struct {
 	Available	*atomic.Bool
 	Listener	net.Listener
+
+	ctx				context.Context
+	cancel			context.CancelFunc
+	onParseError	func(remoteAddr string, err error)
+	requestTimeout	time.Duration
+	halfClose		bool
+	maxBodySize		int64
+	socketMode		os.FileMode
+	removeStale		bool
+	tlsConfig		*TLSConfig
+	acceptor		net.Listener
+	limits			*limiter
+
+	mu		sync.Mutex
+	conns	map[net.Conn]struct{}
+}
+
+// Option configures a Server before it starts accepting connections
+type Option func(*Server)
+
+// WithParseErrorHook replaces the default logging of requests that fail to
+// parse
+func WithParseErrorHook(fn func(remoteAddr string, err error)) Option {
+	return func(s *Server) {
+		s.onParseError = fn
+	}
 }
 
-func Serve(port int, handle Handler) (*Server, error) {
-	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+// WithRequestTimeout bounds how long each request's context stays live
+func WithRequestTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.requestTimeout = d
+	}
+}
+
+// WithHalfClose keeps serving a request after the client shuts down its
+// sending side, for clients that signal the end of their request that
+// way. Without it, the end of the client's stream is taken as the client
+// leaving and cancels the request's context, as a reset or close does.
+func WithHalfClose() Option {
+	return func(s *Server) {
+		s.halfClose = true
+	}
+}
+
+// Serve listens for TCP connections on the loopback interface at port
+func Serve(port int, handle Handler, opts ...Option) (*Server, error) {
+	return ServeAddr(fmt.Sprintf("127.0.0.1:%d", port), handle, opts...)
+}
+
+// ServeAddr listens on addr, which is a host:port pair optionally prefixed
+// with a network scheme such as "tcp4://" or "tcp6://". An empty host
+// binds every interface; IPv6 hosts go in brackets, e.g. "[::1]:8080".
+// "unix:///path/to.sock" listens on a Unix socket and, on Linux,
+// "unix://@name" on an abstract one.
+func ServeAddr(addr string, handle Handler, opts ...Option) (*Server, error) {
+	server := newServer(opts)
+	l, err := server.listenAddr(addr)
 	if err != nil {
 		return nil, err
 	}
-	
-	
-	server :=  &Server{
-		Listener: l,
+	return server.serve(l, handle)
+}
+
+// ServeListener serves connections accepted from l, which lets callers
+// supply listeners built elsewhere: in-memory pipes in tests, inherited
+// sockets or wrapping listeners. The server owns l and closes it on Close.
+func ServeListener(l net.Listener, handle Handler, opts ...Option) (*Server, error) {
+	return newServer(opts).serve(l, handle)
+}
+
+func newServer(opts []Option) *Server {
+	server := &Server{
 		Available: &atomic.Bool{},
+		conns:     make(map[net.Conn]struct{}),
+		limits:    newLimiter(Limits{}),
+		onParseError: func(_ string, err error) {
+			log.Print(err)
+		},
+	}
+	for _, opt := range opts {
+		opt(server)
 	}
+	return server
+}
 
-	server.Available.Store(true)
-	go server.listen(handle)
+func (s *Server) serve(l net.Listener, handle Handler) (*Server, error) {
+	if l == nil {
+		return nil, fmt.Errorf("listener does not exist")
+	}
+	if handle == nil {
+		l.Close()
+		return nil, fmt.Errorf("handler does not exist")
+	}
+
+	s.Listener = l
+	s.acceptor = l
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if s.tlsConfig != nil {
+		conf, err := s.tlsConfig.build(s.ctx)
+		if err != nil {
+			s.cancel()
+			l.Close()
+			return nil, err
+		}
+		s.acceptor = tls.NewListener(l, conf)
+	}
 
-	return server, nil
+	s.Available.Store(true)
+	go s.listen(handle)
+
+	return s, nil
 }
 
+// Close stops the server immediately, cancelling in-flight requests and
+// closing their connections
 func (s *Server) Close() error {
+	err := s.stopAccepting()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// Shutdown stops accepting connections and waits for in-flight ones to
+// finish. If ctx ends first the remaining connections are closed as with
+// Close and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.stopAccepting()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for s.activeConns() > 0 {
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	s.Close()
+	return err
+}
+
+const shutdownPollInterval = 10 * time.Millisecond
+
+func (s *Server) stopAccepting() error {
 	if !s.Available.CompareAndSwap(true, false){
 		return nil
 	}
@@ -50,31 +188,175 @@ func (s *Server) Close() error {
 	return nil
 }
 
+func (s *Server) activeConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// trackConn registers conn as in flight, refusing it once the server has
+// stopped accepting so that Shutdown never waits on a late arrival
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.Available.Load() {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// acceptBackoffMax caps the pause after failed accepts, which usually
+// mean the process has run out of file descriptors
+const acceptBackoffMax = time.Second
+
 func (s *Server) listen(handler Handler) {
+	backoff := time.Duration(0)
 	for {
-		if !s.Available.Load() {
-			continue
-		}
-
-		conn, err := s.Listener.Accept()
+		conn, err := s.acceptor.Accept()
 		if err != nil {
-			if !s.Available.Load(){
+			if !s.Available.Load() || errors.Is(err, net.ErrClosed) {
 				return
 			}
 			log.Print("uh oh:", err, "\n")
+			backoff = min(max(2*backoff, 5*time.Millisecond), acceptBackoffMax)
+			time.Sleep(backoff)
 			continue
 		}
-		go s.handle(conn, handler)
+		backoff = 0
+		if !s.trackConn(conn) {
+			conn.Close()
+			return
+		}
+
+		serve := func() {
+			s.handle(conn, handler)
+		}
+		dropped := func() {
+			s.untrackConn(conn)
+		}
+		if s.limits.admit(conn, serve, dropped, s.ctx.Done()) {
+			go serve()
+		}
 	}
 }
 
 func (s *Server) handle(conn net.Conn, handler Handler) {
-	r, err := request.RequestFromReader(conn)
+	var hijacked atomic.Bool
+	defer s.untrackConn(conn)
+	defer s.limits.releaseConn()
+	defer func() {
+		if !hijacked.Load() {
+			conn.Close()
+		}
+	}()
+	s.limits.active.Add(1)
+	defer s.limits.active.Add(-1)
+	tlsState, err := handshake(conn)
+	if err != nil {
+		s.onParseError(conn.RemoteAddr().String(), err)
+		return
+	}
+	r, err := request.ReadRequest(conn, s.vetHeaders(conn))
 	if err != nil {
-		log.Print(err)
+		var refused *refusal
+		if errors.As(err, &refused) {
+			refuse(conn, refused.status)
+			return
+		}
+		s.onParseError(conn.RemoteAddr().String(), err)
+		return
+	}
+	r.RemoteAddr = conn.RemoteAddr().String()
+	r.TLS = tlsState
+	r.PeerCred = peerCred(netConn(conn))
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	if s.requestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+		defer cancel()
+	}
+	watch := &watcher{conn: conn, cancel: cancel, done: make(chan struct{}), halfClose: s.halfClose}
+	go watch.run()
+
+	res := response.NewWriter(conn)
+	res.SetHijacker(func() (net.Conn, []byte, error) {
+		// the connection leaves the server's hands: it is no longer read,
+		// closed on return or waited on by Shutdown
+		extra := watch.stop()
+		hijacked.Store(true)
+		s.untrackConn(conn)
+		return conn, append(r.Buffered(), extra...), nil
+	})
+	if !s.limits.acquireRequest() {
+		s.limits.unavailable(&res)
 		return
 	}
-	res := response.NewWriter(conn) 
-	handler(&res, r)	
-	conn.Close()
+	defer s.limits.releaseRequest()
+	handler(&res, r.WithContext(ctx))
+}
+
+// netConn returns the transport connection underneath any TLS layer
+func netConn(conn net.Conn) net.Conn {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		return tlsConn.NetConn()
+	}
+	return conn
+}
+
+// watcher cancels the request once the client goes away. Nothing more is
+// expected on the connection after the request, so any read that ends in
+// an error means the peer closed it or the server did. Bytes that do
+// arrive are kept for a handler that hijacks the connection.
+type watcher struct {
+	conn    net.Conn
+	cancel  context.CancelFunc
+	stopped atomic.Bool
+	done    chan struct{}
+	extra   []byte
+	// halfClose keeps the request going when the client stops sending
+	halfClose bool
+}
+
+// maxWatchedBytes caps what the watcher keeps of the bytes a client sends
+// after its request. Past it the watcher stops reading, leaving the rest
+// unread on the connection, which a hijacker still gets in order.
+const maxWatchedBytes = 64 << 10
+
+func (w *watcher) run() {
+	defer close(w.done)
+	buf := make([]byte, 512)
+	for {
+		if len(w.extra) >= maxWatchedBytes {
+			return
+		}
+		n, err := w.conn.Read(buf[:min(len(buf), maxWatchedBytes-len(w.extra))])
+		w.extra = append(w.extra, buf[:n]...)
+		if err != nil {
+			if w.halfClose && errors.Is(err, io.EOF) {
+				return
+			}
+			if !w.stopped.Load() {
+				w.cancel()
+			}
+			return
+		}
+	}
+}
+
+// stop ends the watch without cancelling the request, interrupting the
+// pending read, and returns whatever the watcher read
+func (w *watcher) stop() []byte {
+	w.stopped.Store(true)
+	w.conn.SetReadDeadline(time.Unix(1, 0))
+	<-w.done
+	w.conn.SetReadDeadline(time.Time{})
+	return w.extra
 }
@@ -0,0 +1,391 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingHandler reports the error of each request's context once it is
+// done
+func blockingHandler(done chan<- error) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		done <- req.Context().Err()
+	}
+}
+
+func sendRequest(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	return conn
+}
+
+func waitFor(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("request context was not cancelled")
+		return nil
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	// Test: Client disconnect cancels the context
+	done := make(chan error, 1)
+	s, err := Serve(0, blockingHandler(done))
+	require.NoError(t, err)
+	conn := sendRequest(t, s)
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	assert.ErrorIs(t, waitFor(t, done), context.Canceled)
+	s.Close()
+
+	// Test: With half-close allowed, a client that only stops sending
+	// still gets its response
+	s, err = Serve(0, func(w *response.Writer, req *request.Request) {
+		time.Sleep(50 * time.Millisecond)
+		if req.Context().Err() != nil {
+			w.WriteError(response.InternalError, nil)
+			return
+		}
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(5))
+		w.WriteBody([]byte("hello"))
+	}, WithHalfClose())
+	require.NoError(t, err)
+	conn = sendRequest(t, s)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"), string(out))
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nhello"), string(out))
+	conn.Close()
+	s.Close()
+
+	// Test: Server shutdown cancels the context
+	s, err = Serve(0, blockingHandler(done))
+	require.NoError(t, err)
+	conn = sendRequest(t, s)
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	s.Close()
+	assert.ErrorIs(t, waitFor(t, done), context.Canceled)
+
+	// Test: Request deadline
+	s, err = Serve(0, blockingHandler(done), WithRequestTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+	conn = sendRequest(t, s)
+	defer conn.Close()
+	assert.ErrorIs(t, waitFor(t, done), context.DeadlineExceeded)
+}
+
+// pipeListener hands out in-memory connections created by dial
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (p *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-p.conns:
+		return conn, nil
+	case <-p.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (p *pipeListener) Close() error {
+	close(p.closed)
+	return nil
+}
+
+func (p *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+func (p *pipeListener) dial() net.Conn {
+	client, server := net.Pipe()
+	p.conns <- server
+	return client
+}
+
+func TestWatcherLimit(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	sent := strings.Repeat("x", maxWatchedBytes+1000)
+	go client.Write([]byte(sent))
+
+	cancelled := false
+	w := &watcher{conn: server, cancel: func() { cancelled = true }, done: make(chan struct{})}
+	go w.run()
+	select {
+	case <-w.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("watcher kept reading past its limit")
+	}
+
+	// Test: The watcher keeps at most its limit, leaving the rest unread
+	// on the connection without cancelling
+	extra := w.stop()
+	assert.Len(t, extra, maxWatchedBytes)
+	assert.False(t, cancelled)
+	rest := make([]byte, 1000)
+	_, err := io.ReadFull(server, rest)
+	require.NoError(t, err)
+	assert.Equal(t, sent, string(extra)+string(rest))
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func TestServeListener(t *testing.T) {
+	l := newPipeListener()
+	s, err := ServeListener(l, func(w *response.Writer, req *request.Request) {
+		w.WriteError(response.NotFound, nil)
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn := l.dial()
+	defer conn.Close()
+	go conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Missing listener or handler
+	_, err = ServeListener(nil, func(*response.Writer, *request.Request) {})
+	require.Error(t, err)
+	_, err = ServeListener(newPipeListener(), nil)
+	require.Error(t, err)
+}
+
+func TestServeAddr(t *testing.T) {
+	// Test: Explicit network scheme
+	s, err := ServeAddr("tcp4://127.0.0.1:0", func(*response.Writer, *request.Request) {})
+	require.NoError(t, err)
+	assert.Equal(t, "tcp", s.Listener.Addr().Network())
+	s.Close()
+
+	// Test: Bare host:port
+	s, err = ServeAddr("127.0.0.1:0", func(*response.Writer, *request.Request) {})
+	require.NoError(t, err)
+	s.Close()
+
+	// Test: Unknown network
+	_, err = ServeAddr("sctp://127.0.0.1:0", func(*response.Writer, *request.Request) {})
+	require.Error(t, err)
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.sock")
+	creds := make(chan *request.PeerCred, 1)
+	handle := func(w *response.Writer, req *request.Request) {
+		creds <- req.PeerCred
+		w.WriteError(response.OK, nil)
+	}
+
+	// Test: Stale socket file is removed and permissions applied
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	s, err := ServeAddr("unix://"+path, handle, WithStaleSocketRemoval(), WithSocketMode(0600))
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	conn.Close()
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"))
+
+	cred := <-creds
+	if runtime.GOOS == "linux" {
+		require.NotNil(t, cred)
+		assert.Equal(t, uint32(os.Getuid()), cred.UID)
+		assert.Equal(t, int32(os.Getpid()), cred.PID)
+	}
+
+	// Test: A live socket is never removed
+	_, err = ServeAddr("unix://"+path, handle, WithStaleSocketRemoval())
+	require.Error(t, err)
+	s.Close()
+
+	// Test: Non-socket files are never removed
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0600))
+	_, err = ServeAddr("unix://"+path, handle, WithStaleSocketRemoval())
+	require.Error(t, err)
+
+	// Test: Missing path
+	_, err = ServeAddr("unix://", handle)
+	require.Error(t, err)
+}
+
+func TestServeAbstractSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract sockets are Linux only")
+	}
+	name := fmt.Sprintf("@httpfromtcp-test-%d", os.Getpid())
+	s, err := ServeAddr("unix://"+name, func(w *response.Writer, req *request.Request) {
+		w.WriteError(response.OK, nil)
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("unix", name)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestShutdownDrains(t *testing.T) {
+	release := make(chan struct{})
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		<-release
+		w.WriteError(response.OK, nil)
+	})
+	require.NoError(t, err)
+
+	conn := sendRequest(t, s)
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	// Test: Shutdown waits for the in-flight request
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Shutdown(context.Background())
+	}()
+	select {
+	case <-done:
+		t.Fatal("shutdown returned before the request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.Error(t, err)
+
+	close(release)
+	require.NoError(t, <-done)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Shutdown gives up when its context ends
+	s, err = Serve(0, blockingHandler(make(chan error, 1)))
+	require.NoError(t, err)
+	conn = sendRequest(t, s)
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+}
+
+// TestInheritedListenersHelper is run in a child process by
+// TestInheritedListeners and serves a single request on the inherited socket
+func TestInheritedListenersHelper(t *testing.T) {
+	if os.Getenv("HTTPFROMTCP_TEST_HELPER") != "1" {
+		t.Skip("helper process only")
+	}
+	listeners, err := InheritedListeners()
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	assert.Empty(t, os.Getenv("LISTEN_FDS"))
+
+	served := make(chan struct{})
+	s, err := ServeListener(listeners[0], func(w *response.Writer, req *request.Request) {
+		w.WriteError(response.OK, nil)
+		close(served)
+	})
+	require.NoError(t, err)
+	require.NoError(t, NotifyReady())
+	<-served
+	s.Shutdown(context.Background())
+}
+
+func TestInheritedListeners(t *testing.T) {
+	// Test: Nothing inherited
+	listeners, err := InheritedListeners()
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+
+	// Test: Socket passed to a child process
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer f.Close()
+	readyR, readyW, err := os.Pipe()
+	require.NoError(t, err)
+	defer readyR.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestInheritedListenersHelper$")
+	cmd.ExtraFiles = []*os.File{f, readyW}
+	cmd.Env = append(os.Environ(), "HTTPFROMTCP_TEST_HELPER=1", "LISTEN_FDS=1", readyFDEnv+"=4")
+	require.NoError(t, cmd.Start())
+	readyW.Close()
+	// the parent must not accept on the shared socket
+	l.Close()
+
+	_, err = readyR.Read(make([]byte, 1))
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"))
+	require.NoError(t, cmd.Wait())
+
+	// Test: Sockets meant for another process
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_PID", "1")
+	listeners, err = InheritedListeners()
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+}
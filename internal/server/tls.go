@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Certificate names a PEM encoded certificate chain and its private key
+type Certificate struct {
+	CertFile string
+	KeyFile  string
+}
+
+// TLSConfig turns on TLS for every connection the server accepts
+type TLSConfig struct {
+	// Certificates are selected by the SNI server name the client sends,
+	// falling back to the first one when no certificate matches
+	Certificates []Certificate
+
+	// MinVersion defaults to TLS 1.2
+	MinVersion uint16
+	// CipherSuites restricts the TLS 1.2 cipher suites; TLS 1.3 suites
+	// are not configurable
+	CipherSuites []uint16
+
+	// ClientCAFile holds the CAs used to verify client certificates. When
+	// set, ClientAuth defaults to requiring and verifying a certificate.
+	ClientCAFile string
+	ClientAuth   tls.ClientAuthType
+
+	// ReloadInterval is how often the certificate files are checked for
+	// changes; zero disables reloading
+	ReloadInterval time.Duration
+}
+
+// tlsHandshakeTimeout bounds how long a client may take to complete the
+// handshake before its connection is dropped
+const tlsHandshakeTimeout = 10 * time.Second
+
+// WithTLS serves TLS instead of plaintext
+func WithTLS(cfg TLSConfig) Option {
+	return func(s *Server) {
+		s.tlsConfig = &cfg
+	}
+}
+
+func (cfg *TLSConfig) build(ctx context.Context) (*tls.Config, error) {
+	if len(cfg.Certificates) == 0 {
+		return nil, fmt.Errorf("tls: no certificates configured")
+	}
+	store := &certStore{files: cfg.Certificates}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	if cfg.ReloadInterval > 0 {
+		go store.watch(ctx, cfg.ReloadInterval)
+	}
+
+	conf := &tls.Config{
+		GetCertificate: store.get,
+		MinVersion:     cfg.MinVersion,
+		CipherSuites:   cfg.CipherSuites,
+		ClientAuth:     cfg.ClientAuth,
+	}
+	if conf.MinVersion == 0 {
+		conf.MinVersion = tls.VersionTLS12
+	}
+	if cfg.ClientCAFile != "" {
+		pemBytes, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("tls: no certificates found in %s", cfg.ClientCAFile)
+		}
+		conf.ClientCAs = pool
+		if conf.ClientAuth == tls.NoClientCert {
+			conf.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return conf, nil
+}
+
+// certStore holds the loaded certificates and swaps them out when their
+// files change on disk
+type certStore struct {
+	files []Certificate
+
+	mu      sync.RWMutex
+	certs   []*tls.Certificate
+	modTime time.Time
+}
+
+func (c *certStore) load() error {
+	certs := make([]*tls.Certificate, 0, len(c.files))
+	for _, f := range c.files {
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tls: loading %s: %w", f.CertFile, err)
+		}
+		certs = append(certs, &cert)
+	}
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.certs = certs
+	c.modTime = modTime
+	return nil
+}
+
+func (c *certStore) latestModTime() (time.Time, error) {
+	latest := time.Time{}
+	for _, f := range c.files {
+		for _, path := range []string{f.CertFile, f.KeyFile} {
+			info, err := os.Stat(path)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if info.ModTime().After(latest) {
+				latest = info.ModTime()
+			}
+		}
+	}
+	return latest, nil
+}
+
+// watch reloads the certificates whenever a file is newer than the last
+// load. A failed reload keeps serving the previous certificates, since a
+// cert and key are rarely replaced in a single atomic step.
+func (c *certStore) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTime, err := c.latestModTime()
+		c.mu.RLock()
+		changed := err == nil && modTime.After(c.modTime)
+		c.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := c.load(); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+func (c *certStore) get(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if hello.ServerName != "" {
+		for _, cert := range c.certs {
+			if hello.SupportsCertificate(cert) == nil && certMatchesName(cert, hello.ServerName) {
+				return cert, nil
+			}
+		}
+	}
+	return c.certs[0], nil
+}
+
+func certMatchesName(cert *tls.Certificate, name string) bool {
+	leaf := cert.Leaf
+	if leaf == nil {
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return false
+		}
+		leaf = parsed
+	}
+	return leaf.VerifyHostname(strings.TrimSuffix(name, ".")) == nil
+}
+
+// handshake completes the TLS handshake up front so that handlers can see
+// the negotiated state, including verified client certificates
+func handshake(conn net.Conn) (*tls.ConnectionState, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, nil
+	}
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	defer tlsConn.SetDeadline(time.Time{})
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	state := tlsConn.ConnectionState()
+	return &state, nil
+}
+
+// WriteSelfSignedCert generates a self-signed ECDSA certificate valid for
+// hosts, which may be DNS names or IP addresses, and writes it and its key
+// as PEM files. It is meant for local development only.
+func WriteSelfSignedCert(certFile, keyFile string, hosts ...string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"httpfromtcp development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	if len(hosts) > 0 {
+		template.Subject.CommonName = hosts[0]
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+	return writePEM(keyFile, "PRIVATE KEY", keyDER, 0600)
+}
+
+func writePEM(path, blockType string, der []byte, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
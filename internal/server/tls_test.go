@@ -0,0 +1,122 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colfarl/httpfromtcp/internal/request"
+	"github.com/colfarl/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func selfSigned(t *testing.T, dir, name string, hosts ...string) (Certificate, *x509.CertPool) {
+	t.Helper()
+	cert := Certificate{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, WriteSelfSignedCert(cert.CertFile, cert.KeyFile, hosts...))
+	pemBytes, err := os.ReadFile(cert.CertFile)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(pemBytes))
+	return cert, pool
+}
+
+func tlsGet(t *testing.T, s *Server, conf *tls.Config) (string, *tls.ConnectionState) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", s.Listener.Addr().String(), conf)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	state := conn.ConnectionState()
+	return string(out), &state
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	local, localPool := selfSigned(t, dir, "local", "localhost", "127.0.0.1")
+	other, otherPool := selfSigned(t, dir, "other", "other.test")
+
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		require.NotNil(t, req.TLS)
+		w.WriteError(response.OK, nil)
+	}, WithTLS(TLSConfig{
+		Certificates:   []Certificate{local, other},
+		ReloadInterval: 20 * time.Millisecond,
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Default certificate
+	out, state := tlsGet(t, s, &tls.Config{RootCAs: localPool, ServerName: "localhost"})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.GreaterOrEqual(t, state.Version, uint16(tls.VersionTLS12))
+
+	// Test: SNI selects the matching certificate
+	_, state = tlsGet(t, s, &tls.Config{RootCAs: otherPool, ServerName: "other.test"})
+	assert.Equal(t, []string{"other.test"}, state.PeerCertificates[0].DNSNames)
+
+	// Test: Unknown names fall back to the first certificate
+	_, state = tlsGet(t, s, &tls.Config{InsecureSkipVerify: true, ServerName: "unknown.test"})
+	assert.Equal(t, []string{"localhost"}, state.PeerCertificates[0].DNSNames)
+
+	// Test: Certificates are reloaded when the files change
+	before := state.PeerCertificates[0].SerialNumber
+	require.NoError(t, WriteSelfSignedCert(local.CertFile, local.KeyFile, "localhost"))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(local.CertFile, future, future))
+	require.Eventually(t, func() bool {
+		_, state := tlsGet(t, s, &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"})
+		return state.PeerCertificates[0].SerialNumber.Cmp(before) != 0
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// Test: Missing certificates
+	_, err = Serve(0, func(*response.Writer, *request.Request) {}, WithTLS(TLSConfig{}))
+	require.Error(t, err)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverPool := selfSigned(t, dir, "server", "localhost")
+	clientCert, _ := selfSigned(t, dir, "client", "client.test")
+
+	chains := make(chan [][]*x509.Certificate, 1)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		chains <- req.TLS.VerifiedChains
+		w.WriteError(response.OK, nil)
+	}, WithTLS(TLSConfig{
+		Certificates: []Certificate{serverCert},
+		ClientCAFile: clientCert.CertFile,
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Verified client certificate is visible to the handler
+	pair, err := tls.LoadX509KeyPair(clientCert.CertFile, clientCert.KeyFile)
+	require.NoError(t, err)
+	out, _ := tlsGet(t, s, &tls.Config{RootCAs: serverPool, ServerName: "localhost", Certificates: []tls.Certificate{pair}})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	verified := <-chains
+	require.Len(t, verified, 1)
+	assert.Equal(t, []string{"client.test"}, verified[0][0].DNSNames)
+
+	// Test: Clients without a certificate are refused
+	conn, err := tls.Dial("tcp", s.Listener.Addr().String(), &tls.Config{RootCAs: serverPool, ServerName: "localhost"})
+	if err == nil {
+		defer conn.Close()
+		conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		_, err = io.ReadAll(conn)
+	}
+	require.Error(t, err)
+}